@@ -98,6 +98,8 @@ const (
 	LabelKeySecretType = "argocd.argoproj.io/secret-type"
 	// LabelValueSecretTypeCluster indicates a secret type of cluster
 	LabelValueSecretTypeCluster = "cluster"
+	// LabelKeyNamespaceProject is the namespace label which indicates the project owning the namespace
+	LabelKeyNamespaceProject = "argocd.argoproj.io/project"
 
 	// AnnotationCompareOptions is a comma-separated list of options for comparison
 	AnnotationCompareOptions = "argocd.argoproj.io/compare-options"
@@ -112,6 +114,44 @@ const (
 	// AnnotationKeyRefresh is the annotation key which indicates that app needs to be refreshed. Removed by application controller after app is refreshed.
 	// Might take values 'normal'/'hard'. Value 'hard' means manifest cache and target cluster state cache should be invalidated before refresh.
 	AnnotationKeyRefresh = "argocd.argoproj.io/refresh"
+	// The annotations from here up to AnnotationKeyRequiredLabelSelector are documented in docs/operator-manual/annotations.md
+
+	// AnnotationKeyRefreshRequestedAt holds the RFC3339 timestamp of when the refresh was requested. Removed along with the refresh annotation.
+	AnnotationKeyRefreshRequestedAt = "argocd.argoproj.io/refresh-requested-at"
+	// AnnotationKeyValuesChecksum is the annotation key which holds the checksum of the Helm values resolved during the last refresh of an application
+	AnnotationKeyValuesChecksum = "argocd.argoproj.io/values-checksum"
+	// AnnotationKeyReconciledProject is the annotation key which holds the project an application was validated against during its last reconciliation
+	AnnotationKeyReconciledProject = "argocd.argoproj.io/reconciled-project"
+	// AnnotationKeySyncConcurrency is the project annotation which holds the maximum number of applications of the project allowed to sync at once
+	AnnotationKeySyncConcurrency = "argocd.argoproj.io/sync-concurrency"
+	// AnnotationKeyAllowProtectedNamespaces is the project annotation which, when set to "true", allows applications of the project to be deployed into protected system namespaces
+	AnnotationKeyAllowProtectedNamespaces = "argocd.argoproj.io/allow-protected-namespaces"
+	// AnnotationKeyAllowAutomatedPrune is the project annotation which, when set to "false", forbids applications of the project from enabling automated pruning
+	AnnotationKeyAllowAutomatedPrune = "argocd.argoproj.io/allow-automated-prune"
+	// AnnotationKeyPruneProtectedKinds is the project annotation holding a comma separated list of group/kind resources which automated pruning must never delete
+	AnnotationKeyPruneProtectedKinds = "argocd.argoproj.io/prune-protected-kinds"
+	// AnnotationKeyClusterResourceBlacklist is the project annotation holding a comma separated list of group/kind cluster resources which are denied even if whitelisted
+	AnnotationKeyClusterResourceBlacklist = "argocd.argoproj.io/cluster-resource-blacklist"
+	// AnnotationKeyAppNamePattern is the project annotation holding a regular expression the names of the applications of the project must match, e.g. ^team-a-
+	AnnotationKeyAppNamePattern = "argocd.argoproj.io/app-name-pattern"
+	// AnnotationKeyRequireImmutableRevisions is the project annotation which, when set to "true", requires applications of the project to track a commit SHA or a tag
+	AnnotationKeyRequireImmutableRevisions = "argocd.argoproj.io/require-immutable-revisions"
+	// AnnotationKeyPermittedRevisions is the project annotation holding a YAML map of repository URL globs to the branch globs the applications of the project may track, e.g. '*': [main, release/*]
+	AnnotationKeyPermittedRevisions = "argocd.argoproj.io/permitted-revisions"
+	// AnnotationKeySourceNamespaces is the project annotation holding a comma separated list of namespace globs the applications of the project may be created in
+	AnnotationKeySourceNamespaces = "argocd.argoproj.io/source-namespaces"
+	// AnnotationKeyHealthOverrides is the project annotation holding a YAML map of custom health check Lua scripts keyed by group/kind
+	AnnotationKeyHealthOverrides = "argocd.argoproj.io/health-overrides"
+	// AnnotationKeySyncTimeout is the application or project annotation holding the duration after which a sync operation times out, e.g. 10m
+	AnnotationKeySyncTimeout = "argocd.argoproj.io/sync-timeout"
+	// AnnotationKeyResourceExclusions is the application annotation holding a comma separated list of group/kind resources excluded from the sync, e.g. apps/Deployment or ConfigMap for the core group
+	AnnotationKeyResourceExclusions = "argocd.argoproj.io/resource-exclusions"
+	// AnnotationKeyIgnoreDifferences is the project annotation holding a YAML map of ignored differences keyed by group/kind, e.g. apps/Deployment: {jsonPointers: [/spec/replicas]}
+	AnnotationKeyIgnoreDifferences = "argocd.argoproj.io/ignore-differences"
+	// AnnotationKeyResourceAnnotations is the application or project annotation holding a YAML map of annotations to apply to every managed resource
+	AnnotationKeyResourceAnnotations = "argocd.argoproj.io/resource-annotations"
+	// AnnotationKeyRequiredLabelSelector is the project annotation holding a label selector, e.g. team=platform, the labels of the applications of the project must match
+	AnnotationKeyRequiredLabelSelector = "argocd.argoproj.io/required-label-selector"
 	// AnnotationKeyManagedBy is annotation name which indicates that k8s resource is managed by an application.
 	AnnotationKeyManagedBy = "managed-by"
 	// AnnotationValueManagedByArgoCD is a 'managed-by' annotation value for resources managed by Argo CD
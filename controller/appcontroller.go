@@ -818,18 +818,12 @@ func (ctrl *ApplicationController) processAppRefreshQueueItem() (processNext boo
 	var sErr bool
 	project, err := ctrl.getAppProj(app)
 	if err != nil {
-		logCtx.Infof("Could not lookup project for %s in order to check maintenance state", app.Name)
+		logCtx.Infof("Could not lookup project for %s in order to check auto-sync eligibility", app.Name)
 	} else {
-		active := project.Spec.Maintenance.ActiveWindows()
-		match, _ := active.Match(app)
-		if match {
-			logCtx.Infof("Maintenance window active, skipping sync")
-		} else {
-			syncErrCond := ctrl.autoSync(app, compareResult.syncStatus, compareResult.resources)
-			if syncErrCond != nil {
-				sErr = true
-				app.Status.SetConditions([]appv1.ApplicationCondition{*syncErrCond}, map[appv1.ApplicationConditionType]bool{appv1.ApplicationConditionSyncError: true})
-			}
+		syncErrCond := ctrl.autoSync(app, project, compareResult.syncStatus, compareResult.resources)
+		if syncErrCond != nil {
+			sErr = true
+			app.Status.SetConditions([]appv1.ApplicationCondition{*syncErrCond}, map[appv1.ApplicationConditionType]bool{appv1.ApplicationConditionSyncError: true})
 		}
 	}
 	if !sErr {
@@ -882,29 +876,14 @@ func (ctrl *ApplicationController) needRefreshAppStatus(app *appv1.Application,
 
 func (ctrl *ApplicationController) refreshAppConditions(app *appv1.Application) bool {
 	errorConditions := make([]appv1.ApplicationCondition, 0)
-	proj, err := ctrl.getAppProj(app)
+	specConditions, err := argo.ValidateProjectPermissions(context.Background(), &app.Spec, applisters.NewAppProjectLister(ctrl.projInformer.GetIndexer()), ctrl.namespace, ctrl.db)
 	if err != nil {
-		if apierr.IsNotFound(err) {
-			errorConditions = append(errorConditions, appv1.ApplicationCondition{
-				Type:    appv1.ApplicationConditionInvalidSpecError,
-				Message: fmt.Sprintf("Application referencing project %s which does not exist", app.Spec.Project),
-			})
-		} else {
-			errorConditions = append(errorConditions, appv1.ApplicationCondition{
-				Type:    appv1.ApplicationConditionUnknownError,
-				Message: err.Error(),
-			})
-		}
+		errorConditions = append(errorConditions, appv1.ApplicationCondition{
+			Type:    appv1.ApplicationConditionUnknownError,
+			Message: err.Error(),
+		})
 	} else {
-		specConditions, err := argo.ValidatePermissions(context.Background(), &app.Spec, proj, ctrl.db)
-		if err != nil {
-			errorConditions = append(errorConditions, appv1.ApplicationCondition{
-				Type:    appv1.ApplicationConditionUnknownError,
-				Message: err.Error(),
-			})
-		} else {
-			errorConditions = append(errorConditions, specConditions...)
-		}
+		errorConditions = append(errorConditions, specConditions...)
 	}
 	app.Status.SetConditions(errorConditions, map[appv1.ApplicationConditionType]bool{
 		appv1.ApplicationConditionInvalidSpecError:        true,
@@ -953,6 +932,10 @@ func (ctrl *ApplicationController) persistAppStatus(orig *appv1.Application, new
 			newAnnotations[k] = v
 		}
 		delete(newAnnotations, common.AnnotationKeyRefresh)
+		delete(newAnnotations, common.AnnotationKeyRefreshRequestedAt)
+	}
+	if len(newStatus.Conditions) > 0 {
+		newStatus.Conditions = argo.SortConditions(newStatus.Conditions)
 	}
 	patch, modified, err := diff.CreateTwoWayMergePatch(
 		&appv1.Application{ObjectMeta: metav1.ObjectMeta{Annotations: orig.GetAnnotations()}, Status: orig.Status},
@@ -976,24 +959,19 @@ func (ctrl *ApplicationController) persistAppStatus(orig *appv1.Application, new
 }
 
 // autoSync will initiate a sync operation for an application configured with automated sync
-func (ctrl *ApplicationController) autoSync(app *appv1.Application, syncStatus *appv1.SyncStatus, resources []appv1.ResourceStatus) *appv1.ApplicationCondition {
+func (ctrl *ApplicationController) autoSync(app *appv1.Application, proj *appv1.AppProject, syncStatus *appv1.SyncStatus, resources []appv1.ResourceStatus) *appv1.ApplicationCondition {
 	if app.Spec.SyncPolicy == nil || app.Spec.SyncPolicy.Automated == nil {
 		return nil
 	}
 	logCtx := log.WithFields(log.Fields{"application": app.Name})
-	if app.Operation != nil {
-		logCtx.Infof("Skipping auto-sync: another operation is in progress")
-		return nil
-	}
-	if app.DeletionTimestamp != nil && !app.DeletionTimestamp.IsZero() {
-		logCtx.Infof("Skipping auto-sync: deletion in progress")
-		return nil
-	}
 
-	// Only perform auto-sync if we detect OutOfSync status. This is to prevent us from attempting
-	// a sync when application is already in a Synced or Unknown state
-	if syncStatus.Status != appv1.SyncStatusCodeOutOfSync {
-		logCtx.Infof("Skipping auto-sync: application status is %s", syncStatus.Status)
+	// The decision is made against the sync status of the current comparison, which is not persisted in the
+	// application status yet. Only perform auto-sync if we detect OutOfSync status. This is to prevent us from
+	// attempting a sync when application is already in a Synced or Unknown state
+	compared := *app
+	compared.Status.Sync = *syncStatus
+	if ok, reason := argo.ShouldAutoSync(&compared, proj); !ok {
+		logCtx.Infof("Skipping auto-sync: %s", reason)
 		return nil
 	}
 
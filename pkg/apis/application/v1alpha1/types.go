@@ -694,6 +694,26 @@ const (
 	ApplicationConditionExcludedResourceWarning = "ExcludedResourceWarning"
 	// ApplicationConditionOrphanedResourceWarning indicates that application has orphaned resources
 	ApplicationConditionOrphanedResourceWarning = "OrphanedResourceWarning"
+	// ApplicationConditionProtectedNamespaceWarning indicates that application is deployed into a protected system namespace
+	ApplicationConditionProtectedNamespaceWarning = "ProtectedNamespaceWarning"
+	// ApplicationConditionHelmParameterOverrideInfo indicates that a Helm parameter overrides an inline Helm value with a different value
+	ApplicationConditionHelmParameterOverrideInfo = "HelmParameterOverrideInfo"
+	// ApplicationConditionAnnotationSizeWarning indicates that the total size of the application annotations is approaching the Kubernetes limit
+	ApplicationConditionAnnotationSizeWarning = "AnnotationSizeWarning"
+	// ApplicationConditionUnmatchedValueFilesWarning indicates that a Helm value file glob does not match any file
+	ApplicationConditionUnmatchedValueFilesWarning = "UnmatchedValueFilesWarning"
+	// ApplicationConditionTemplateInjectionWarning indicates that Helm values contain template markers
+	ApplicationConditionTemplateInjectionWarning = "TemplateInjectionWarning"
+	// ApplicationConditionDirectoryFileCountWarning indicates that a recursive directory application includes more files than allowed
+	ApplicationConditionDirectoryFileCountWarning = "DirectoryFileCountWarning"
+	// ApplicationConditionNameCollisionWarning indicates that an application of another namespace has the same name and destination
+	ApplicationConditionNameCollisionWarning = "NameCollisionWarning"
+	// ApplicationConditionMaintenanceWindowWarning indicates that a self-healing application is blocked by maintenance windows of its project
+	ApplicationConditionMaintenanceWindowWarning = "MaintenanceWindowWarning"
+	// ApplicationConditionNamespaceMismatchWarning indicates that the Helm values set a namespace which differs from the destination namespace
+	ApplicationConditionNamespaceMismatchWarning = "NamespaceMismatchWarning"
+	// ApplicationConditionPruneProtectedWarning indicates that automated pruning may delete a resource protected from pruning by the project
+	ApplicationConditionPruneProtectedWarning = "PruneProtectedWarning"
 )
 
 // ApplicationCondition contains details about current application condition
@@ -1185,7 +1205,7 @@ func (p *AppProject) ValidateProject() error {
 			if _, ok := existingPolicies[policy]; ok {
 				return status.Errorf(codes.AlreadyExists, "policy '%s' already exists for role '%s'", policy, role.Name)
 			}
-			if err := validatePolicy(p.Name, role.Name, policy); err != nil {
+			if err := ValidatePolicy(p.Name, role.Name, policy); err != nil {
 				return err
 			}
 			existingPolicies[policy] = true
@@ -1241,7 +1261,8 @@ func isValidAction(action string) bool {
 	return validActions[action]
 }
 
-func validatePolicy(proj string, role string, policy string) error {
+// ValidatePolicy verifies the casbin policy line of the given project role is well formed
+func ValidatePolicy(proj string, role string, policy string) error {
 	policyComponents := strings.Split(policy, ",")
 	if len(policyComponents) != 6 || strings.Trim(policyComponents[0], " ") != "p" {
 		return status.Errorf(codes.InvalidArgument, "invalid policy rule '%s': must be of the form: 'p, sub, res, act, obj, eft'", policy)
@@ -1531,10 +1552,14 @@ func (w *ProjectMaintenanceWindows) Match(app *Application) (bool, ProjectMainte
 }
 
 func (w ProjectMaintenanceWindow) Active() bool {
+	return w.ActiveAt(time.Now())
+}
+
+// ActiveAt returns whether the window is active at the given time
+func (w ProjectMaintenanceWindow) ActiveAt(now time.Time) bool {
 	specParser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
 	schedule, _ := specParser.Parse(w.Schedule)
 	duration, _ := time.ParseDuration(w.Duration)
-	now := time.Now()
 	nextWindow := schedule.Next(now.Add(-duration))
 	return nextWindow.Before(now)
 }
@@ -1797,15 +1822,20 @@ func globMatch(pattern string, val string) bool {
 }
 
 // IsSourcePermitted validates if the provided application's source is a one of the allowed sources for the project.
+// A source repo prefixed with '!' denies the repositories it matches, even if another source repo allows them.
 func (proj AppProject) IsSourcePermitted(src ApplicationSource) bool {
 	srcNormalized := git.NormalizeGitURL(src.RepoURL)
+	permitted := false
 	for _, repoURL := range proj.Spec.SourceRepos {
-		normalized := git.NormalizeGitURL(repoURL)
-		if globMatch(normalized, srcNormalized) {
-			return true
+		if strings.HasPrefix(repoURL, "!") {
+			if globMatch(git.NormalizeGitURL(strings.TrimPrefix(repoURL, "!")), srcNormalized) {
+				return false
+			}
+		} else if globMatch(git.NormalizeGitURL(repoURL), srcNormalized) {
+			permitted = true
 		}
 	}
-	return false
+	return permitted
 }
 
 // IsDestinationPermitted validates if the provided application's destination is one of the allowed destinations for the project
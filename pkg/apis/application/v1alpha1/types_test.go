@@ -29,6 +29,16 @@ func TestAppProject_IsSourcePermitted(t *testing.T) {
 		projSources: []string{"https://github.com/argoproj/*.git"}, appSource: "https://github.com/argoproj1/test2.git", isPermitted: false,
 	}, {
 		projSources: []string{"https://github.com/argoproj/foo"}, appSource: "https://github.com/argoproj/foo1", isPermitted: false,
+	}, {
+		projSources: []string{"*", "!https://github.com/argoproj/test.git"}, appSource: "https://github.com/argoproj/test.git", isPermitted: false,
+	}, {
+		projSources: []string{"!https://github.com/argoproj/test.git", "*"}, appSource: "https://github.com/argoproj/test.git", isPermitted: false,
+	}, {
+		projSources: []string{"https://github.com/argoproj/*", "!https://github.com/argoproj/internal-*"}, appSource: "https://github.com/argoproj/internal-tools", isPermitted: false,
+	}, {
+		projSources: []string{"https://github.com/argoproj/*", "!https://github.com/argoproj/internal-*"}, appSource: "https://github.com/argoproj/argo-cd", isPermitted: true,
+	}, {
+		projSources: []string{"!https://github.com/argoproj/test.git"}, appSource: "https://github.com/argoproj/argo-cd", isPermitted: false,
 	}}
 
 	for _, data := range testData {
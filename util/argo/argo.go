@@ -4,18 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 
 	"github.com/argoproj/argo-cd/common"
 	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
@@ -25,14 +36,58 @@ import (
 	"github.com/argoproj/argo-cd/util"
 	"github.com/argoproj/argo-cd/util/db"
 	"github.com/argoproj/argo-cd/util/git"
+	"github.com/argoproj/argo-cd/util/hash"
 	"github.com/argoproj/argo-cd/util/helm"
 	"github.com/argoproj/argo-cd/util/kube"
+	"github.com/argoproj/argo-cd/util/settings"
 )
 
 const (
 	errDestinationMissing = "Destination server and/or namespace missing from app spec"
+	// totalAnnotationSizeLimit is the maximum total size in bytes of the annotations of a Kubernetes object
+	totalAnnotationSizeLimit = 256 * 1024
+	// annotationSizeWarningThreshold is the total annotations size in bytes above which a warning is emitted
+	annotationSizeWarningThreshold = totalAnnotationSizeLimit * 9 / 10
+	// maxResourceNameLength is the maximum length of a Kubernetes resource name
+	maxResourceNameLength = 253
 )
 
+// ClusterLister lists the clusters configured in Argo CD. It is satisfied by db.ArgoDB.
+type ClusterLister interface {
+	ListClusters(ctx context.Context) (*argoappv1.ClusterList, error)
+}
+
+// ClusterVersionResolver returns the Kubernetes version of a configured cluster, e.g. "1.14"
+type ClusterVersionResolver interface {
+	GetClusterVersion(server string) (string, error)
+}
+
+// RevisionType is the kind of git reference a target revision points to
+type RevisionType string
+
+const (
+	RevisionTypeSHA    RevisionType = "SHA"
+	RevisionTypeTag    RevisionType = "Tag"
+	RevisionTypeBranch RevisionType = "Branch"
+)
+
+// ChangeKind is the kind of change detected for an application which requires it to be refreshed
+type ChangeKind string
+
+const (
+	// ChangeKindSpec is a change of the application spec
+	ChangeKindSpec ChangeKind = "Spec"
+	// ChangeKindRevision is a new commit of the tracked git revision
+	ChangeKindRevision ChangeKind = "Revision"
+	// ChangeKindValues is a change of the Helm values resolved from ConfigMaps and Secrets
+	ChangeKindValues ChangeKind = "Values"
+)
+
+// floatingRevisions are well-known tag and branch names which are commonly moved to newer commits
+var floatingRevisions = map[string]bool{"latest": true, "stable": true, "main": true, "master": true}
+
+var semverTagRegex = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
 // FormatAppConditions returns string representation of give app condition list
 func FormatAppConditions(conditions []argoappv1.ApplicationCondition) string {
 	formattedConditions := make([]string, 0)
@@ -67,7 +122,8 @@ func RefreshApp(appIf v1alpha1.ApplicationInterface, name string, refreshType ar
 	metadata := map[string]interface{}{
 		"metadata": map[string]interface{}{
 			"annotations": map[string]string{
-				common.AnnotationKeyRefresh: string(refreshType),
+				common.AnnotationKeyRefresh:            string(refreshType),
+				common.AnnotationKeyRefreshRequestedAt: time.Now().UTC().Format(time.RFC3339),
 			},
 		},
 	}
@@ -91,6 +147,20 @@ func RefreshApp(appIf v1alpha1.ApplicationInterface, name string, refreshType ar
 	return nil, err
 }
 
+// RefreshPendingDuration returns how long the refresh of the application has been pending.
+// Returns false if no refresh is pending or the time the refresh was requested is unknown.
+func RefreshPendingDuration(app *argoappv1.Application, now time.Time) (time.Duration, bool) {
+	annotations := app.GetAnnotations()
+	if _, ok := annotations[common.AnnotationKeyRefresh]; !ok {
+		return 0, false
+	}
+	requestedAt, err := time.Parse(time.RFC3339, annotations[common.AnnotationKeyRefreshRequestedAt])
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(requestedAt), true
+}
+
 // WaitForRefresh watches an application until its comparison timestamp is after the refresh timestamp
 // If refresh timestamp is not present, will use current timestamp at time of call
 func WaitForRefresh(ctx context.Context, appIf v1alpha1.ApplicationInterface, name string, timeout *time.Duration) (*argoappv1.Application, error) {
@@ -261,9 +331,51 @@ func enrichSpec(spec *argoappv1.ApplicationSpec, appDetails *apiclient.RepoAppDe
 	}
 }
 
-// ValidatePermissions ensures that the referenced cluster has been added to Argo CD and the app source repo and destination namespace/cluster are permitted in app project
-func ValidatePermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, proj *argoappv1.AppProject, db db.ArgoDB) ([]argoappv1.ApplicationCondition, error) {
+// DetailsSourceType infers the application source type from the populated block of the app details response.
+// An empty string is returned if no block is populated.
+func DetailsSourceType(appDetails *apiclient.RepoAppDetailsResponse) argoappv1.ApplicationSourceType {
+	switch {
+	case appDetails.Kustomize != nil:
+		return argoappv1.ApplicationSourceTypeKustomize
+	case appDetails.Helm != nil:
+		return argoappv1.ApplicationSourceTypeHelm
+	case appDetails.Ksonnet != nil:
+		return argoappv1.ApplicationSourceTypeKsonnet
+	case appDetails.Directory != nil:
+		return argoappv1.ApplicationSourceTypeDirectory
+	}
+	return ""
+}
+
+// ValidateKsonnetEnvironment verifies the ksonnet environment referenced by the application source exists in the app details
+func ValidateKsonnetEnvironment(spec *argoappv1.ApplicationSpec, appDetails *apiclient.RepoAppDetailsResponse) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if spec.Source.Ksonnet == nil || spec.Source.Ksonnet.Environment == "" {
+		return conditions
+	}
+	if appDetails.Ksonnet != nil {
+		if _, ok := appDetails.Ksonnet.Environments[spec.Source.Ksonnet.Environment]; ok {
+			return conditions
+		}
+	}
+	conditions = append(conditions, argoappv1.ApplicationCondition{
+		Type:    argoappv1.ApplicationConditionInvalidSpecError,
+		Message: fmt.Sprintf("ksonnet environment '%s' does not exist in the application", spec.Source.Ksonnet.Environment),
+	})
+	return conditions
+}
+
+// ValidatePermissions ensures that the referenced cluster has been added to Argo CD and the app source repo and destination namespace/cluster are permitted in app project.
+// A warning is emitted if the destination namespace is one of the given protected namespaces, unless the project allows protected namespaces.
+func ValidatePermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, proj *argoappv1.AppProject, db db.ArgoDB, protectedNamespaces ...string) ([]argoappv1.ApplicationCondition, error) {
 	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if spec.Source.Chart != "" && spec.Source.RepoURL == "" {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("spec.source.repoURL is required for chart '%s'", spec.Source.Chart),
+		})
+		return conditions, nil
+	}
 	if spec.Source.RepoURL == "" || (spec.Source.Path == "" && spec.Source.Chart == "") {
 		conditions = append(conditions, argoappv1.ApplicationCondition{
 			Type:    argoappv1.ApplicationConditionInvalidSpecError,
@@ -272,10 +384,44 @@ func ValidatePermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, p
 		return conditions, nil
 	}
 
-	if !proj.IsSourcePermitted(spec.Source) {
+	if escapesRepoRoot(spec.Source.Path) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application path '%s' is outside of the repository", spec.Source.Path),
+		})
+	}
+
+	if scheme, ok := repoURLScheme(spec.Source.RepoURL); ok && !supportedRepoSchemes[scheme] {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application repo %s uses unsupported scheme '%s'", spec.Source.RepoURL, scheme),
+		})
+	}
+
+	if spec.SyncPolicy != nil && spec.SyncPolicy.Automated != nil && spec.SyncPolicy.Automated.Prune && proj.GetAnnotations()[common.AnnotationKeyAllowAutomatedPrune] == "false" {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("automated pruning is not permitted in project '%s'", spec.GetProject()),
+		})
+	}
+
+	if spec.Source.Chart == "" && proj.GetAnnotations()[common.AnnotationKeyRequireImmutableRevisions] == "true" && !isImmutableRevision(spec.Source.TargetRevision) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application revision '%s' is not immutable, which is required in project '%s'", spec.Source.TargetRevision, spec.GetProject()),
+		})
+	}
+
+	if spec.Source.Chart == "" {
+		conditions = append(conditions, ValidatePermittedRevisions(spec, proj)...)
+	}
+
+	source := spec.Source
+	source.RepoURL = StripCredentialsTemplate(source.RepoURL)
+	if !proj.IsSourcePermitted(source) {
 		conditions = append(conditions, argoappv1.ApplicationCondition{
 			Type:    argoappv1.ApplicationConditionInvalidSpecError,
-			Message: fmt.Sprintf("application repo %s is not permitted in project '%s'", spec.Source.RepoURL, spec.Project),
+			Message: fmt.Sprintf("application repo %s is not permitted in project '%s'", source.RepoURL, spec.Project),
 		})
 	}
 
@@ -286,6 +432,19 @@ func ValidatePermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, p
 				Message: fmt.Sprintf("application destination %v is not permitted in project '%s'", spec.Destination, spec.Project),
 			})
 		}
+		if isProtectedNamespace(spec.Destination.Namespace, protectedNamespaces) && proj.GetAnnotations()[common.AnnotationKeyAllowProtectedNamespaces] != "true" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionProtectedNamespaceWarning,
+				Message: fmt.Sprintf("application destination namespace '%s' is a protected system namespace", spec.Destination.Namespace),
+			})
+		}
+		if u, err := url.Parse(spec.Destination.Server); err != nil || u.Scheme == "" || u.Host == "" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("application destination server '%s' is not a valid URL", spec.Destination.Server),
+			})
+			return conditions, nil
+		}
 		// Ensure the k8s cluster the app is referencing, is configured in Argo CD
 		_, err := db.GetCluster(ctx, spec.Destination.Server)
 		if err != nil {
@@ -304,11 +463,105 @@ func ValidatePermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, p
 	return conditions, nil
 }
 
+// supportedRepoSchemes are the URL schemes of the repositories applications can be deployed from
+var supportedRepoSchemes = map[string]bool{"https": true, "http": true, "ssh": true, "git": true, "oci": true, "file": true}
+
+// repoURLScheme returns the lower cased scheme of the repository URL. SCP-like URLs such as git@github.com:org/repo
+// have no scheme.
+func repoURLScheme(repoURL string) (string, bool) {
+	i := strings.Index(repoURL, "://")
+	if i <= 0 {
+		return "", false
+	}
+	return strings.ToLower(repoURL[:i]), true
+}
+
+// escapesRepoRoot returns true if the source path refers to a location outside of the repository once cleaned
+func escapesRepoRoot(path string) bool {
+	cleaned := filepath.Clean(path)
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// ValidateProjectPermissions resolves the project of the application and validates the permissions of the spec
+// against it. A project which does not exist is reported as a condition rather than an error.
+func ValidateProjectPermissions(ctx context.Context, spec *argoappv1.ApplicationSpec, projLister applicationsv1.AppProjectLister, ns string, db db.ArgoDB, protectedNamespaces ...string) ([]argoappv1.ApplicationCondition, error) {
+	proj, err := GetAppProject(spec, projLister, ns)
+	if err != nil {
+		if apierr.IsNotFound(err) {
+			return []argoappv1.ApplicationCondition{{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("Application referencing project %s which does not exist", spec.GetProject()),
+			}}, nil
+		}
+		return nil, err
+	}
+	return ValidatePermissions(ctx, spec, proj, db, protectedNamespaces...)
+}
+
+func isProtectedNamespace(namespace string, protectedNamespaces []string) bool {
+	for _, protected := range protectedNamespaces {
+		if namespace == protected {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNamespaceOwnership verifies the destination namespace of the application is not owned by another project.
+// Namespaces are owned by a project when labeled with the project name; unlabeled namespaces may be used by any project.
+func ValidateNamespaceOwnership(app *argoappv1.Application, proj *argoappv1.AppProject, nsLister corev1listers.NamespaceLister) ([]argoappv1.ApplicationCondition, error) {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if app.Spec.Destination.Namespace == "" {
+		return conditions, nil
+	}
+	ns, err := nsLister.Get(app.Spec.Destination.Namespace)
+	if err != nil {
+		if apierr.IsNotFound(err) {
+			return conditions, nil
+		}
+		return nil, err
+	}
+	owner, ok := ns.Labels[common.LabelKeyNamespaceProject]
+	if ok && owner != proj.Name {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("namespace '%s' is owned by project '%s'", ns.Name, owner),
+		})
+	}
+	return conditions, nil
+}
+
+// ResolveProjectForApp returns the project name of the application. The explicitly configured project is preferred,
+// followed by the project mapped to the namespace of the application and finally the default project.
+func ResolveProjectForApp(app *argoappv1.Application, nsToProject map[string]string) string {
+	if app.Spec.Project != "" {
+		return app.Spec.Project
+	}
+	if proj, ok := nsToProject[app.Namespace]; ok && proj != "" {
+		return proj
+	}
+	return common.DefaultAppProjectName
+}
+
 // GetAppProject returns a project from an application
 func GetAppProject(spec *argoappv1.ApplicationSpec, projLister applicationsv1.AppProjectLister, ns string) (*argoappv1.AppProject, error) {
 	return projLister.AppProjects(ns).Get(spec.GetProject())
 }
 
+// GetAppProjectCtx returns a project from an application, aborting if the given context is already done.
+// No tracing backend is configured, so the resolved project is recorded in the debug log instead of a span.
+func GetAppProjectCtx(ctx context.Context, spec *argoappv1.ApplicationSpec, projLister applicationsv1.AppProjectLister, ns string) (*argoappv1.AppProject, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	proj, err := GetAppProject(spec, projLister, ns)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{"project": proj.Name, "namespace": ns}).Debug("Resolved application project")
+	return proj, nil
+}
+
 // verifyGenerateManifests verifies a repo path can generate manifests
 func verifyGenerateManifests(
 	ctx context.Context,
@@ -395,6 +648,54 @@ func ContainsSyncResource(name string, gvk schema.GroupVersionKind, rr []argoapp
 	return false
 }
 
+// ValidateSyncResources verifies that each of the resources selected for sync is managed by the application
+func ValidateSyncResources(resources []argoappv1.SyncOperationResource, managed []argoappv1.ResourceRef) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	managedResources := make([]argoappv1.SyncOperationResource, len(managed))
+	for i, ref := range managed {
+		managedResources[i] = argoappv1.SyncOperationResource{Group: ref.Group, Kind: ref.Kind, Name: ref.Name}
+	}
+	for _, r := range resources {
+		if !ContainsSyncResource(r.Name, schema.GroupVersionKind{Group: r.Group, Kind: r.Kind}, managedResources) {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("sync resource %s/%s/%s is not managed by the application", r.Group, r.Kind, r.Name),
+			})
+		}
+	}
+	return conditions
+}
+
+// UnionSyncResources combines the given sync operation resource lists into a single list without duplicates.
+// Resources are matched using the same semantics as ContainsSyncResource and keep their first seen order.
+func UnionSyncResources(lists ...[]argoappv1.SyncOperationResource) []argoappv1.SyncOperationResource {
+	union := make([]argoappv1.SyncOperationResource, 0)
+	for _, list := range lists {
+		for _, r := range list {
+			if !ContainsSyncResource(r.Name, schema.GroupVersionKind{Group: r.Group, Kind: r.Kind}, union) {
+				union = append(union, r)
+			}
+		}
+	}
+	return union
+}
+
+// SortSyncResources returns a copy of the sync resources ordered by group, kind and name
+func SortSyncResources(rr []argoappv1.SyncOperationResource) []argoappv1.SyncOperationResource {
+	sorted := make([]argoappv1.SyncOperationResource, len(rr))
+	copy(sorted, rr)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Group != sorted[j].Group {
+			return sorted[i].Group < sorted[j].Group
+		}
+		if sorted[i].Kind != sorted[j].Kind {
+			return sorted[i].Kind < sorted[j].Kind
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
 // NormalizeApplicationSpec will normalize an application spec to a preferred state. This is used
 // for migrating application objects which are using deprecated legacy fields into the new fields,
 // and defaulting fields in the spec (e.g. spec.project)
@@ -407,17 +708,1710 @@ func NormalizeApplicationSpec(spec *argoappv1.ApplicationSpec) *argoappv1.Applic
 	// 3. If any app sources are their zero values, then nil out the pointers to the source spec.
 	// This makes it easier for users to switch between app source types if they are not using
 	// any of the source-specific parameters.
-	if spec.Source.Kustomize != nil && spec.Source.Kustomize.IsZero() {
+	if isZeroKustomize(spec.Source.Kustomize) {
 		spec.Source.Kustomize = nil
 	}
-	if spec.Source.Helm != nil && spec.Source.Helm.IsZero() {
+	if isZeroHelm(spec.Source.Helm) {
 		spec.Source.Helm = nil
+	} else {
+		normalizeHelmParameters(spec.Source.Helm.Parameters)
 	}
-	if spec.Source.Ksonnet != nil && spec.Source.Ksonnet.IsZero() {
+	if isZeroKsonnet(spec.Source.Ksonnet) {
 		spec.Source.Ksonnet = nil
 	}
-	if spec.Source.Directory != nil && spec.Source.Directory.IsZero() {
+	if isZeroDirectory(spec.Source.Directory) {
 		spec.Source.Directory = nil
 	}
+	normalizeEmptySlices(spec)
 	return spec
 }
+
+// normalizeEmptySlices replaces empty slices and maps of the spec with nil, so that specs which only differ in nil
+// versus empty collections compare equal
+func normalizeEmptySlices(spec *argoappv1.ApplicationSpec) {
+	if helm := spec.Source.Helm; helm != nil {
+		if len(helm.ValueFiles) == 0 {
+			helm.ValueFiles = nil
+		}
+		if len(helm.Parameters) == 0 {
+			helm.Parameters = nil
+		}
+	}
+	if kustomize := spec.Source.Kustomize; kustomize != nil {
+		if len(kustomize.Images) == 0 {
+			kustomize.Images = nil
+		}
+		if len(kustomize.CommonLabels) == 0 {
+			kustomize.CommonLabels = nil
+		}
+	}
+	if ksonnet := spec.Source.Ksonnet; ksonnet != nil && len(ksonnet.Parameters) == 0 {
+		ksonnet.Parameters = nil
+	}
+	if directory := spec.Source.Directory; directory != nil {
+		if len(directory.Jsonnet.ExtVars) == 0 {
+			directory.Jsonnet.ExtVars = nil
+		}
+		if len(directory.Jsonnet.TLAs) == 0 {
+			directory.Jsonnet.TLAs = nil
+		}
+	}
+	if plugin := spec.Source.Plugin; plugin != nil && len(plugin.Env) == 0 {
+		plugin.Env = nil
+	}
+	if len(spec.IgnoreDifferences) == 0 {
+		spec.IgnoreDifferences = nil
+	}
+	if len(spec.Info) == 0 {
+		spec.Info = nil
+	}
+}
+
+// isZeroKustomize returns true if the kustomize source is either unset or holds its zero value
+func isZeroKustomize(k *argoappv1.ApplicationSourceKustomize) bool {
+	return k == nil || k.IsZero()
+}
+
+// isZeroHelm returns true if the helm source is either unset or holds its zero value
+func isZeroHelm(h *argoappv1.ApplicationSourceHelm) bool {
+	return h == nil || h.IsZero()
+}
+
+// normalizeHelmParameters clears the forceString flag of parameters whose value Helm would interpret as a string
+// anyway. The order of the parameters is kept since the last of several parameters with the same name takes
+// precedence.
+func normalizeHelmParameters(params []argoappv1.HelmParameter) {
+	for i := range params {
+		if params[i].ForceString && !isTypedHelmValue(params[i].Value) {
+			params[i].ForceString = false
+		}
+	}
+}
+
+// HelmParametersEqual returns true if both parameter lists set the same values, regardless of the order of parameters
+// with different names and of forceString flags which do not change how Helm interprets the value
+func HelmParametersEqual(left []argoappv1.HelmParameter, right []argoappv1.HelmParameter) bool {
+	return reflect.DeepEqual(sortedHelmParameters(left), sortedHelmParameters(right))
+}
+
+// sortedHelmParameters returns a normalized copy of the parameters stably sorted by name
+func sortedHelmParameters(params []argoappv1.HelmParameter) []argoappv1.HelmParameter {
+	sorted := make([]argoappv1.HelmParameter, len(params))
+	copy(sorted, params)
+	normalizeHelmParameters(sorted)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// isTypedHelmValue returns true if Helm interprets the --set value as a boolean, integer or null rather than a string.
+// Mirrors the typing of Helm's strvals parser, which matches keywords regardless of case and keeps integers with a
+// leading zero as strings.
+func isTypedHelmValue(value string) bool {
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") || strings.EqualFold(value, "null") || value == "0" {
+		return true
+	}
+	if len(value) == 0 || value[0] == '0' {
+		return false
+	}
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// isZeroKsonnet returns true if the ksonnet source is either unset or holds its zero value
+func isZeroKsonnet(k *argoappv1.ApplicationSourceKsonnet) bool {
+	return k == nil || k.IsZero()
+}
+
+// isZeroDirectory returns true if the directory source is either unset or holds its zero value
+func isZeroDirectory(d *argoappv1.ApplicationSourceDirectory) bool {
+	return d == nil || d.IsZero()
+}
+
+// GetKustomizeImages returns the image references declared as kustomize image overrides of the given source.
+// Overrides of the form 'name=newimage:tag' are reported using the new image reference.
+func GetKustomizeImages(source *argoappv1.ApplicationSource) []string {
+	images := make([]string, 0)
+	if source == nil || source.Kustomize == nil {
+		return images
+	}
+	for _, image := range source.Kustomize.Images {
+		ref := string(image)
+		if parts := strings.SplitN(ref, "=", 2); len(parts) == 2 {
+			ref = parts[1]
+		}
+		if ref != "" {
+			images = append(images, ref)
+		}
+	}
+	return images
+}
+
+// ValidateRequiredLabels verifies the application labels match the label selector held by the required-label-selector
+// annotation of its project. No conditions are returned if the project does not require a selector.
+func ValidateRequiredLabels(app *argoappv1.Application, proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	required, ok := proj.GetAnnotations()[common.AnnotationKeyRequiredLabelSelector]
+	if !ok {
+		return conditions
+	}
+	selector, err := labels.Parse(required)
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("required label selector of project '%s' is invalid: %v", proj.Name, err),
+		})
+		return conditions
+	}
+	if !selector.Matches(labels.Set(app.Labels)) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application labels do not match required selector '%s'", selector.String()),
+		})
+	}
+	return conditions
+}
+
+// DetectResourceOverlap returns the resources which are claimed by more than one application along with the
+// sorted names of the applications claiming them. Resources are matched by group, version, kind, namespace and name.
+func DetectResourceOverlap(apps map[string][]argoappv1.ResourceRef) map[argoappv1.ResourceRef][]string {
+	claims := make(map[argoappv1.ResourceRef]map[string]bool)
+	for appName, refs := range apps {
+		for _, ref := range refs {
+			key := argoappv1.ResourceRef{Group: ref.Group, Version: ref.Version, Kind: ref.Kind, Namespace: ref.Namespace, Name: ref.Name}
+			if _, ok := claims[key]; !ok {
+				claims[key] = make(map[string]bool)
+			}
+			claims[key][appName] = true
+		}
+	}
+	overlaps := make(map[argoappv1.ResourceRef][]string)
+	for key, appNames := range claims {
+		if len(appNames) < 2 {
+			continue
+		}
+		names := make([]string, 0, len(appNames))
+		for name := range appNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		overlaps[key] = names
+	}
+	return overlaps
+}
+
+// EffectiveNamespaceResourcePolicy returns the de-duplicated namespaced resource whitelist and blacklist of the project.
+// Projects do not restrict namespaced resources by whitelist, so the whitelist always permits every group and kind,
+// and the blacklist takes precedence as it does in AppProject.IsResourcePermitted.
+func EffectiveNamespaceResourcePolicy(proj *argoappv1.AppProject) ([]metav1.GroupKind, []metav1.GroupKind) {
+	whitelist := []metav1.GroupKind{{Group: "*", Kind: "*"}}
+	blacklist := make([]metav1.GroupKind, 0)
+	seen := make(map[metav1.GroupKind]bool)
+	for _, gk := range proj.Spec.NamespaceResourceBlacklist {
+		if seen[gk] {
+			continue
+		}
+		seen[gk] = true
+		blacklist = append(blacklist, gk)
+	}
+	return whitelist, blacklist
+}
+
+// ProjectPermitsClusterResource returns whether the project permits deploying the cluster scoped resource. The resource
+// must match the cluster resource whitelist of the project and must not match the cluster-resource-blacklist
+// annotation, which takes precedence. Both lists support glob patterns. Cluster resources which are listed in neither
+// are denied, as they are by AppProject.IsResourcePermitted.
+func ProjectPermitsClusterResource(proj *argoappv1.AppProject, group, kind string) bool {
+	blacklist := parseGroupKinds(proj.GetAnnotations()[common.AnnotationKeyClusterResourceBlacklist])
+	if matchesGroupKind(group, kind, blacklist) {
+		return false
+	}
+	return matchesGroupKind(group, kind, proj.Spec.ClusterResourceWhitelist)
+}
+
+// ValidateImmutableAnnotations verifies the protected annotations were not modified or removed by an update of the application.
+// Protected annotations which were not previously set may be added.
+func ValidateImmutableAnnotations(oldApp, newApp *argoappv1.Application, keys []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	oldAnnotations := oldApp.GetAnnotations()
+	newAnnotations := newApp.GetAnnotations()
+	for _, key := range keys {
+		oldValue, existed := oldAnnotations[key]
+		if !existed {
+			continue
+		}
+		if newValue, ok := newAnnotations[key]; !ok || newValue != oldValue {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("annotation '%s' is immutable", key),
+			})
+		}
+	}
+	return conditions
+}
+
+// ClassifyRevision returns whether the target revision is a commit SHA, a tag or a branch. Revisions are classified
+// without contacting the repository: fully qualified tag references and semantic versions are considered tags, and
+// anything else which is not a commit SHA, including an empty revision (HEAD), is considered a branch.
+func ClassifyRevision(revision string) RevisionType {
+	switch {
+	case git.IsCommitSHA(revision):
+		return RevisionTypeSHA
+	case strings.HasPrefix(revision, "refs/tags/"), semverTagRegex.MatchString(revision):
+		return RevisionTypeTag
+	default:
+		return RevisionTypeBranch
+	}
+}
+
+// isImmutableRevision returns true if the revision is a commit SHA or a tag which is not one of the well-known
+// floating tags
+func isImmutableRevision(revision string) bool {
+	switch ClassifyRevision(revision) {
+	case RevisionTypeSHA:
+		return true
+	case RevisionTypeTag:
+		return !floatingRevisions[strings.TrimPrefix(revision, "refs/tags/")]
+	default:
+		return false
+	}
+}
+
+// ValidatePermittedRevisions verifies the target revision of the application is permitted by the branch globs, keyed by
+// repository URL glob, held by the permitted-revisions annotation of the project. Only branches are restricted; commit
+// SHAs and tags are always permitted, as are repositories which do not match any of the keys. Annotations which cannot
+// be parsed are reported rather than ignored so that the restriction fails closed.
+func ValidatePermittedRevisions(spec *argoappv1.ApplicationSpec, proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	value, ok := proj.GetAnnotations()[common.AnnotationKeyPermittedRevisions]
+	if !ok {
+		return conditions
+	}
+	revision := spec.Source.TargetRevision
+	if ClassifyRevision(revision) != RevisionTypeBranch {
+		return conditions
+	}
+	permitted := make(map[string][]string)
+	if err := yaml.Unmarshal([]byte(value), &permitted); err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("permitted revisions of project '%s' are invalid: %v", spec.GetProject(), err),
+		})
+		return conditions
+	}
+	if revision == "" {
+		revision = "HEAD"
+	}
+	branch := strings.TrimPrefix(revision, "refs/heads/")
+	repoURL := git.NormalizeGitURL(spec.Source.RepoURL)
+	restricted := false
+	for repoGlob, revisionGlobs := range permitted {
+		if repoGlob != "*" && !globMatch(git.NormalizeGitURL(repoGlob), repoURL) {
+			continue
+		}
+		restricted = true
+		for _, revisionGlob := range revisionGlobs {
+			if globMatch(revisionGlob, branch) {
+				return conditions
+			}
+		}
+	}
+	if restricted {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application revision '%s' is not permitted in project '%s'", revision, spec.GetProject()),
+		})
+	}
+	return conditions
+}
+
+func globMatch(pattern string, val string) bool {
+	ok, err := filepath.Match(pattern, val)
+	return ok && err == nil
+}
+
+// DedupeConditions removes conditions with the same type and message as an earlier condition, preserving the order in
+// which conditions first appear.
+func DedupeConditions(conditions []argoappv1.ApplicationCondition) []argoappv1.ApplicationCondition {
+	deduped := make([]argoappv1.ApplicationCondition, 0, len(conditions))
+	seen := make(map[argoappv1.ApplicationCondition]bool)
+	for _, condition := range conditions {
+		if seen[condition] {
+			continue
+		}
+		seen[condition] = true
+		deduped = append(deduped, condition)
+	}
+	return deduped
+}
+
+// WouldExceedSyncConcurrency returns true if starting another sync would exceed the sync concurrency limit of the project.
+// The limit is read from the project's sync concurrency annotation; a missing, invalid or zero limit means unlimited.
+func WouldExceedSyncConcurrency(proj *argoappv1.AppProject, activeSyncs int) bool {
+	value, ok := proj.GetAnnotations()[common.AnnotationKeySyncConcurrency]
+	if !ok {
+		return false
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warnf("Invalid sync concurrency '%s' of project '%s': %v", value, proj.Name, err)
+		return false
+	}
+	return limit > 0 && activeSyncs >= limit
+}
+
+// ValidateProjectRoles verifies that the policies of the project roles reference declared roles and are well formed
+func ValidateProjectRoles(proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	declared := make(map[string]bool)
+	for _, role := range proj.Spec.Roles {
+		declared[role.Name] = true
+	}
+	subjectPrefix := fmt.Sprintf("proj:%s:", proj.Name)
+	for _, role := range proj.Spec.Roles {
+		for _, policy := range role.Policies {
+			if components := strings.Split(policy, ","); len(components) > 1 {
+				subject := strings.TrimSpace(components[1])
+				if referenced := strings.TrimPrefix(subject, subjectPrefix); referenced != subject && !declared[referenced] {
+					conditions = append(conditions, argoappv1.ApplicationCondition{
+						Type:    argoappv1.ApplicationConditionInvalidSpecError,
+						Message: fmt.Sprintf("policy '%s' of role '%s' references undefined role '%s'", policy, role.Name, referenced),
+					})
+					continue
+				}
+			}
+			if err := argoappv1.ValidatePolicy(proj.Name, role.Name, policy); err != nil {
+				conditions = append(conditions, argoappv1.ApplicationCondition{
+					Type:    argoappv1.ApplicationConditionInvalidSpecError,
+					Message: status.Convert(err).Message(),
+				})
+			}
+		}
+	}
+	return conditions
+}
+
+// IsFreshApp returns true if the application has never been reconciled, i.e. it has no sync status, health status,
+// reconciliation time or operation state
+func IsFreshApp(app *argoappv1.Application) bool {
+	status := app.Status
+	return status.Sync.Status == "" &&
+		status.Health.Status == "" &&
+		status.ReconciledAt == nil &&
+		status.OperationState == nil
+}
+
+// SourceKey returns a stable key identifying the location of the source: the normalized repo URL, the path or chart
+// and the target revision. Source parameters and values are ignored.
+func SourceKey(source *argoappv1.ApplicationSource) string {
+	location := source.Path
+	if source.Chart != "" {
+		location = source.Chart
+	}
+	return strings.Join([]string{git.NormalizeGitURL(source.RepoURL), location, source.TargetRevision}, "|")
+}
+
+// ResolvePermittedDestinations expands the server globs of the project destinations against the configured clusters
+// and returns the concrete server and namespace pairs permitted by the project
+func ResolvePermittedDestinations(ctx context.Context, proj *argoappv1.AppProject, clusters ClusterLister) ([]argoappv1.ApplicationDestination, error) {
+	clusterList, err := clusters.ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	destinations := make([]argoappv1.ApplicationDestination, 0)
+	seen := make(map[argoappv1.ApplicationDestination]bool)
+	for _, dst := range proj.Spec.Destinations {
+		for _, cluster := range clusterList.Items {
+			if dst.Server != "*" && !globMatch(dst.Server, cluster.Server) {
+				continue
+			}
+			resolved := argoappv1.ApplicationDestination{Server: cluster.Server, Namespace: dst.Namespace}
+			if seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+			destinations = append(destinations, resolved)
+		}
+	}
+	return destinations, nil
+}
+
+// ValidateAnnotationSize verifies the total size of the application annotations does not exceed the Kubernetes limit.
+// A warning is emitted once the size reaches 90% of the limit.
+func ValidateAnnotationSize(app *argoappv1.Application) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	size := 0
+	for k, v := range app.GetAnnotations() {
+		size += len(k) + len(v)
+	}
+	switch {
+	case size > totalAnnotationSizeLimit:
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application annotations size of %d bytes exceeds the limit of %d bytes", size, totalAnnotationSizeLimit),
+		})
+	case size >= annotationSizeWarningThreshold:
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionAnnotationSizeWarning,
+			Message: fmt.Sprintf("application annotations size of %d bytes is approaching the limit of %d bytes", size, totalAnnotationSizeLimit),
+		})
+	}
+	return conditions
+}
+
+// LikelyIdenticalManifests returns true if the normalized specs only differ in fields which do not affect the rendered
+// manifests, such as the sync policy, ignored differences, info or project. Only the source and destination are
+// compared.
+func LikelyIdenticalManifests(a, b *argoappv1.ApplicationSpec) bool {
+	a = NormalizeApplicationSpec(a)
+	b = NormalizeApplicationSpec(b)
+	return a.Source.Equals(b.Source) && a.Destination.Equals(b.Destination)
+}
+
+// ValidateClusterResourcePolicy verifies the cluster resource whitelist of the project is well formed and does not
+// overlap with the given cluster resource blacklist
+func ValidateClusterResourcePolicy(proj *argoappv1.AppProject, blacklist []metav1.GroupKind) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	blacklisted := make(map[metav1.GroupKind]bool)
+	for _, gk := range blacklist {
+		blacklisted[gk] = true
+	}
+	for _, gk := range proj.Spec.ClusterResourceWhitelist {
+		if err := validateGroupKind(gk); err != nil {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("cluster resource whitelist entry '%s' is invalid: %v", gk.String(), err),
+			})
+			continue
+		}
+		if blacklisted[gk] {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("cluster resource '%s' is both whitelisted and blacklisted", gk.String()),
+			})
+		}
+	}
+	return conditions
+}
+
+// validateGroupKind verifies the kind is set and both the group and kind are valid glob patterns
+func validateGroupKind(gk metav1.GroupKind) error {
+	if gk.Kind == "" {
+		return fmt.Errorf("kind is required")
+	}
+	for _, pattern := range []string{gk.Group, gk.Kind} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// IsTerminalPhase returns true if the operation phase is final, i.e. the operation succeeded, failed or errored
+func IsTerminalPhase(phase argoappv1.OperationPhase) bool {
+	return phase.Completed()
+}
+
+// IsSuccessfulPhase returns true if the operation phase indicates the operation succeeded
+func IsSuccessfulPhase(phase argoappv1.OperationPhase) bool {
+	return phase.Successful()
+}
+
+// conditionHints maps condition types to hints on how to remediate them
+var conditionHints = map[argoappv1.ApplicationConditionType]string{
+	argoappv1.ApplicationConditionInvalidSpecError:          "fix the application spec; for a Helm chart source make sure spec.source.targetRevision is set to a chart version",
+	argoappv1.ApplicationConditionComparisonError:           "check that the repository and destination cluster are reachable, then refresh the application",
+	argoappv1.ApplicationConditionSyncError:                 "review the last sync operation result and retry the sync",
+	argoappv1.ApplicationConditionDeletionError:             "check the controller logs and remove any finalizers blocking the deletion",
+	argoappv1.ApplicationConditionSharedResourceWarning:     "make sure each resource is managed by a single application",
+	argoappv1.ApplicationConditionRepeatedResourceWarning:   "remove the duplicate resource definitions from the application source",
+	argoappv1.ApplicationConditionExcludedResourceWarning:   "remove the resource from the application source or update resource.exclusions in argocd-cm",
+	argoappv1.ApplicationConditionOrphanedResourceWarning:   "delete the orphaned resources or add them to the application source",
+	argoappv1.ApplicationConditionProtectedNamespaceWarning: "deploy into a different namespace or allow protected namespaces in the project",
+	argoappv1.ApplicationConditionAnnotationSizeWarning:     "remove large annotations from the application",
+}
+
+// ExplainCondition returns the condition message followed by a hint on how to remediate it. The raw message is returned
+// for condition types without a known hint.
+func ExplainCondition(c argoappv1.ApplicationCondition) string {
+	hint, ok := conditionHints[c.Type]
+	if !ok {
+		return c.Message
+	}
+	return fmt.Sprintf("%s (hint: %s)", c.Message, hint)
+}
+
+// DeprecatedFieldsUsed returns the names of the deprecated spec fields used by the application
+func DeprecatedFieldsUsed(spec *argoappv1.ApplicationSpec) []string {
+	fields := make([]string, 0)
+	if !isZeroKsonnet(spec.Source.Ksonnet) {
+		fields = append(fields, "spec.source.ksonnet")
+	}
+	return fields
+}
+
+// ValidateClusterVersion verifies the Kubernetes version of the destination cluster is at least the required minimum
+func ValidateClusterVersion(spec *argoappv1.ApplicationSpec, requiredMin string, resolver ClusterVersionResolver) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	minVersion, err := semver.NewVersion(requiredMin)
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("invalid minimum Kubernetes version '%s': %v", requiredMin, err),
+		})
+		return conditions
+	}
+	serverVersion, err := resolver.GetClusterVersion(spec.Destination.Server)
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("unable to determine the Kubernetes version of cluster '%s': %v", spec.Destination.Server, err),
+		})
+		return conditions
+	}
+	// Some providers report the minor version with a trailing '+', e.g. 1.14+
+	version, err := semver.NewVersion(strings.TrimSuffix(serverVersion, "+"))
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("unable to parse the Kubernetes version '%s' of cluster '%s': %v", serverVersion, spec.Destination.Server, err),
+		})
+		return conditions
+	}
+	if version.LessThan(minVersion) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("cluster '%s' runs Kubernetes %s but at least %s is required", spec.Destination.Server, serverVersion, requiredMin),
+		})
+	}
+	return conditions
+}
+
+// ApplyProjectPluginEnv merges the default plugin environment of the project into the plugin environment of the
+// source. Entries of the source override project entries with the same name. Sources which do not use a config
+// management plugin are left untouched.
+func ApplyProjectPluginEnv(projectEnv argoappv1.Env, source *argoappv1.ApplicationSource) {
+	if source.Plugin == nil || len(projectEnv) == 0 {
+		return
+	}
+	overridden := make(map[string]bool)
+	for _, entry := range source.Plugin.Env {
+		overridden[entry.Name] = true
+	}
+	env := make(argoappv1.Env, 0, len(projectEnv)+len(source.Plugin.Env))
+	for _, entry := range projectEnv {
+		if !overridden[entry.Name] {
+			env = append(env, &argoappv1.EnvEntry{Name: entry.Name, Value: entry.Value})
+		}
+	}
+	source.Plugin.Env = append(env, source.Plugin.Env...)
+}
+
+// PermittedNamespaces returns the sorted and de-duplicated namespaces explicitly permitted by the project destinations.
+// The returned boolean is true if any destination namespace is a glob pattern, e.g. '*', which the list cannot enumerate.
+func PermittedNamespaces(proj *argoappv1.AppProject) ([]string, bool) {
+	namespaces := make([]string, 0)
+	seen := make(map[string]bool)
+	wildcard := false
+	for _, dst := range proj.Spec.Destinations {
+		if strings.ContainsAny(dst.Namespace, "*?[") {
+			wildcard = true
+			continue
+		}
+		if seen[dst.Namespace] {
+			continue
+		}
+		seen[dst.Namespace] = true
+		namespaces = append(namespaces, dst.Namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, wildcard
+}
+
+// IsAppReady returns true if the application is synced and healthy and has no pending or running operation
+func IsAppReady(app *argoappv1.Application) bool {
+	if app.Operation != nil {
+		return false
+	}
+	if app.Status.OperationState != nil && !app.Status.OperationState.Phase.Completed() {
+		return false
+	}
+	return app.Status.Sync.Status == argoappv1.SyncStatusCodeSynced && app.Status.Health.Status == argoappv1.HealthStatusHealthy
+}
+
+// lookupIP resolves host names to IP addresses. It is a variable so tests can stub out DNS.
+var lookupIP = net.LookupIP
+
+// ValidateDestinationCIDR verifies the API server of the destination resolves to addresses within the allowed CIDRs.
+// Destinations without a server URL are skipped.
+func ValidateDestinationCIDR(dest *argoappv1.ApplicationDestination, allowed []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if dest.Server == "" {
+		return conditions
+	}
+	networks := make([]*net.IPNet, 0, len(allowed))
+	for _, cidr := range allowed {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("invalid allowed CIDR '%s': %v", cidr, err),
+			})
+			continue
+		}
+		networks = append(networks, network)
+	}
+	serverURL, err := url.Parse(dest.Server)
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("invalid destination server '%s': %v", dest.Server, err),
+		})
+		return conditions
+	}
+	ips := []net.IP{net.ParseIP(serverURL.Hostname())}
+	if ips[0] == nil {
+		if ips, err = lookupIP(serverURL.Hostname()); err != nil {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("unable to resolve destination server '%s': %v", dest.Server, err),
+			})
+			return conditions
+		}
+	}
+	for _, ip := range ips {
+		if !ipInNetworks(ip, networks) {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("destination server '%s' resolves to %s which is outside of the allowed CIDRs", dest.Server, ip.String()),
+			})
+		}
+	}
+	return conditions
+}
+
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RolesAllowingAction returns the names of the project roles whose policies allow the action on the given object,
+// e.g. 'my-project/guestbook'. Roles with a matching deny policy are excluded. Malformed policies are ignored.
+func RolesAllowingAction(proj *argoappv1.AppProject, action, object string) []string {
+	roles := make([]string, 0)
+	for _, role := range proj.Spec.Roles {
+		allowed := false
+		denied := false
+		for _, policy := range role.Policies {
+			if argoappv1.ValidatePolicy(proj.Name, role.Name, policy) != nil {
+				continue
+			}
+			components := strings.Split(policy, ",")
+			policyAction := strings.TrimSpace(components[3])
+			policyObject := strings.TrimSpace(components[4])
+			if (policyAction != "*" && policyAction != action) || !globMatch(policyObject, object) {
+				continue
+			}
+			if strings.TrimSpace(components[5]) == "deny" {
+				denied = true
+			} else {
+				allowed = true
+			}
+		}
+		if allowed && !denied {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles
+}
+
+// syncPolicyOptions returns the sync options configured by the sync policy, keyed by their spec path
+func syncPolicyOptions(policy *argoappv1.SyncPolicy) map[string]bool {
+	automated := policy != nil && policy.Automated != nil
+	return map[string]bool{
+		"automated":          automated,
+		"automated.prune":    automated && policy.Automated.Prune,
+		"automated.selfHeal": automated && policy.Automated.SelfHeal,
+	}
+}
+
+// SyncPolicyDiff lists the sync options for which the sync policy of the application differs from the default sync
+// policy of its project, in the form 'option: app=<value> project=<value>'
+func SyncPolicyDiff(app *argoappv1.Application, projectDefault *argoappv1.SyncPolicy) []string {
+	appOptions := syncPolicyOptions(app.Spec.SyncPolicy)
+	projectOptions := syncPolicyOptions(projectDefault)
+	diff := make([]string, 0)
+	for _, option := range []string{"automated", "automated.prune", "automated.selfHeal"} {
+		if appOptions[option] != projectOptions[option] {
+			diff = append(diff, fmt.Sprintf("%s: app=%t project=%t", option, appOptions[option], projectOptions[option]))
+		}
+	}
+	return diff
+}
+
+// ValidateAppInfo verifies the info items of the application have a name and that values which are URLs are well formed
+func ValidateAppInfo(spec *argoappv1.ApplicationSpec) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	for i, info := range spec.Info {
+		if strings.TrimSpace(info.Name) == "" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("spec.info[%d] has an empty name", i),
+			})
+		}
+		if !strings.Contains(info.Value, "://") {
+			continue
+		}
+		if u, err := url.Parse(info.Value); err != nil || u.Scheme == "" || u.Host == "" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("spec.info[%d] '%s' has a malformed URL '%s'", i, info.Name, info.Value),
+			})
+		}
+	}
+	return conditions
+}
+
+// OrphanedApps returns the names of the applications referencing a project which does not exist
+func OrphanedApps(apps []argoappv1.Application, existingProjects map[string]bool) []string {
+	orphaned := make([]string, 0)
+	for _, app := range apps {
+		if !existingProjects[app.Spec.GetProject()] {
+			orphaned = append(orphaned, app.Name)
+		}
+	}
+	return orphaned
+}
+
+// ValidateDirectoryRecurse warns when a recursive directory application includes more files than the given limit.
+// Applications which do not recurse into directories are not checked.
+func ValidateDirectoryRecurse(spec *argoappv1.ApplicationSpec, fileCount int, limit int) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if spec.Source.Directory == nil || !spec.Source.Directory.Recurse {
+		return conditions
+	}
+	if fileCount > limit {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionDirectoryFileCountWarning,
+			Message: fmt.Sprintf("recursive directory '%s' includes %d files which exceeds the limit of %d", spec.Source.Path, fileCount, limit),
+		})
+	}
+	return conditions
+}
+
+// DistinctHelmRepos returns the sorted, de-duplicated URLs of the Helm chart repositories referenced by the
+// applications, including OCI registries. Git repositories are not included.
+func DistinctHelmRepos(apps []argoappv1.Application) []string {
+	repos := make(map[string]bool)
+	for _, app := range apps {
+		if app.Spec.Source.Chart == "" || app.Spec.Source.RepoURL == "" {
+			continue
+		}
+		repos[normalizeHelmRepoURL(app.Spec.Source.RepoURL)] = true
+	}
+	distinct := make([]string, 0, len(repos))
+	for repo := range repos {
+		distinct = append(distinct, repo)
+	}
+	sort.Strings(distinct)
+	return distinct
+}
+
+// normalizeHelmRepoURL normalizes a Helm repository URL for purposes of comparison
+func normalizeHelmRepoURL(repo string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(repo)), "/")
+}
+
+// ValidateAppNameUniqueness warns when an application of another namespace has the same name and deploys to the same
+// destination as the given application
+func ValidateAppNameUniqueness(app *argoappv1.Application, siblings []argoappv1.Application) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	for _, sibling := range siblings {
+		if sibling.Name != app.Name || sibling.Namespace == app.Namespace {
+			continue
+		}
+		if sibling.Spec.Destination.Server != app.Spec.Destination.Server || sibling.Spec.Destination.Namespace != app.Spec.Destination.Namespace {
+			continue
+		}
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionNameCollisionWarning,
+			Message: fmt.Sprintf("application '%s/%s' has the same name and destination", sibling.Namespace, sibling.Name),
+		})
+	}
+	return conditions
+}
+
+// StripCredentialsTemplate removes the user info embedded in an HTTP(S) repository URL, so that the URL can be matched
+// against the source repositories of a project. Other URLs, such as SSH URLs, are returned unchanged.
+func StripCredentialsTemplate(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.User == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return repoURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// RefreshTypeForChange returns the type of refresh required by the given kind of change. Spec and revision changes
+// are part of the manifest cache key and only need a normal refresh, while changes of the resolved Helm values are not
+// visible to the repo server and require a hard refresh to invalidate the cached manifests.
+func RefreshTypeForChange(change ChangeKind) argoappv1.RefreshType {
+	switch change {
+	case ChangeKindValues:
+		return argoappv1.RefreshTypeHard
+	default:
+		return argoappv1.RefreshTypeNormal
+	}
+}
+
+// ValidateSourceRepoPatterns reports the source repository patterns of the project which are both allowed and denied.
+// A pattern prefixed with '!' denies the repositories it matches, so AppProject.IsSourcePermitted never permits a
+// repository matching a pattern which is both allowed and denied.
+func ValidateSourceRepoPatterns(proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	allowed := make(map[string]bool)
+	for _, pattern := range proj.Spec.SourceRepos {
+		if !strings.HasPrefix(pattern, "!") {
+			allowed[git.NormalizeGitURL(pattern)] = true
+		}
+	}
+	for _, pattern := range proj.Spec.SourceRepos {
+		if !strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		denied := strings.TrimPrefix(pattern, "!")
+		if allowed[git.NormalizeGitURL(denied)] {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("source repo pattern '%s' of project '%s' is both allowed and denied", denied, proj.Name),
+			})
+		}
+	}
+	return conditions
+}
+
+// AppAge returns the time elapsed since the application was created, or zero if the creation timestamp is not set
+func AppAge(app *argoappv1.Application, now time.Time) time.Duration {
+	if app.CreationTimestamp.IsZero() {
+		return 0
+	}
+	return now.Sub(app.CreationTimestamp.Time)
+}
+
+// TimeSinceLastSync returns the time elapsed since the last operation of the application finished. It returns false
+// if the application has never completed an operation.
+func TimeSinceLastSync(app *argoappv1.Application, now time.Time) (time.Duration, bool) {
+	state := app.Status.OperationState
+	if state == nil || state.FinishedAt == nil {
+		return 0, false
+	}
+	return now.Sub(state.FinishedAt.Time), true
+}
+
+// ValidateKustomizeNaming reports the resource names which would exceed the Kubernetes name length limit once the
+// Kustomize name prefix of the application is applied to the given base names
+func ValidateKustomizeNaming(spec *argoappv1.ApplicationSpec, baseNames []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	kustomize := spec.Source.Kustomize
+	if kustomize == nil || kustomize.NamePrefix == "" {
+		return conditions
+	}
+	for _, name := range baseNames {
+		if len(kustomize.NamePrefix)+len(name) > maxResourceNameLength {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("resource name '%s' exceeds %d characters with name prefix '%s'", name, maxResourceNameLength, kustomize.NamePrefix),
+			})
+		}
+	}
+	return conditions
+}
+
+// detailsOptions holds the source options which affect the details reported by the repo server
+type detailsOptions struct {
+	ValueFiles         []string `json:"valueFiles,omitempty"`
+	KsonnetEnvironment string   `json:"ksonnetEnvironment,omitempty"`
+}
+
+// DetailsCacheKey returns a stable cache key for the repo server details of the source. The key includes the
+// normalized repository URL, the target revision, the path, the chart and the source options which affect the details.
+func DetailsCacheKey(source *argoappv1.ApplicationSource) (string, error) {
+	var options detailsOptions
+	if source.Helm != nil {
+		options.ValueFiles = source.Helm.ValueFiles
+	}
+	if source.Ksonnet != nil {
+		options.KsonnetEnvironment = source.Ksonnet.Environment
+	}
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("appdetails|%s|%s|%s|%s|%d", git.NormalizeGitURL(source.RepoURL), source.TargetRevision, source.Path, source.Chart, hash.FNVa(string(optionsJSON))), nil
+}
+
+// ValidateSyncWindowCompatibility warns when the application enables automated self-healing while maintenance windows
+// of its project block its syncs, since self-heal attempts are skipped whenever one of the windows is active
+func ValidateSyncWindowCompatibility(app *argoappv1.Application, proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil || !policy.Automated.SelfHeal || !proj.Spec.Maintenance.IsEnabled() {
+		return conditions
+	}
+	if match, windows := proj.Spec.Maintenance.Windows.Match(app); match {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionMaintenanceWindowWarning,
+			Message: fmt.Sprintf("self-heal is enabled but %d maintenance window(s) of project '%s' block syncs of the application", len(windows), proj.Name),
+		})
+	}
+	return conditions
+}
+
+// UnionPermittedDestinations returns the destinations permitted by any of the given projects, without duplicates and
+// in the order they are declared
+func UnionPermittedDestinations(projs []*argoappv1.AppProject) []argoappv1.ApplicationDestination {
+	destinations := make([]argoappv1.ApplicationDestination, 0)
+	seen := make(map[argoappv1.ApplicationDestination]bool)
+	for _, proj := range projs {
+		for _, dst := range proj.Spec.Destinations {
+			if seen[dst] {
+				continue
+			}
+			seen[dst] = true
+			destinations = append(destinations, dst)
+		}
+	}
+	return destinations
+}
+
+// selfHealCooldown is the minimum time between the end of an operation and a self-heal attempt. It matches the default
+// of the --self-heal-timeout-seconds flag of the application controller.
+const selfHealCooldown = 5 * time.Second
+
+// SelfHealEligible returns whether a self-heal sync of the application may be initiated at the given time, along with
+// the reason when it may not. Self-heal is blocked by an operation in progress, a project forbidding the automated
+// pruning the application enables, an active maintenance window of the project matching the application, and the
+// cooldown following the last operation.
+func SelfHealEligible(app *argoappv1.Application, proj *argoappv1.AppProject, now time.Time) (bool, string) {
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil || !policy.Automated.SelfHeal {
+		return false, "self-heal is not enabled"
+	}
+	if app.Operation != nil {
+		return false, "another operation is in progress"
+	}
+	if policy.Automated.Prune && proj.GetAnnotations()[common.AnnotationKeyAllowAutomatedPrune] == "false" {
+		return false, fmt.Sprintf("automated pruning is not permitted in project '%s'", proj.Name)
+	}
+	if proj.Spec.Maintenance.IsEnabled() {
+		var active argoappv1.ProjectMaintenanceWindows
+		for _, window := range proj.Spec.Maintenance.Windows {
+			if window.ActiveAt(now) {
+				active = append(active, window)
+			}
+		}
+		if match, _ := active.Match(app); match {
+			return false, "maintenance window active"
+		}
+	}
+	if state := app.Status.OperationState; state != nil {
+		if state.FinishedAt == nil {
+			return false, "last operation has not finished"
+		}
+		if retryAfter := selfHealCooldown - now.Sub(state.FinishedAt.Time); retryAfter > 0 {
+			return false, fmt.Sprintf("self-heal cooldown, retrying in %v", retryAfter)
+		}
+	}
+	return true, ""
+}
+
+// ShouldAutoSync returns whether an automated sync of the application should be initiated, along with the reason
+// when it should not. It does not consider previous sync attempts, which the controller tracks separately.
+func ShouldAutoSync(app *argoappv1.Application, proj *argoappv1.AppProject) (bool, string) {
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil {
+		return false, "automated sync is not enabled"
+	}
+	if app.Operation != nil {
+		return false, "another operation is in progress"
+	}
+	if app.DeletionTimestamp != nil && !app.DeletionTimestamp.IsZero() {
+		return false, "deletion in progress"
+	}
+	if active := proj.Spec.Maintenance.ActiveWindows(); len(active) > 0 {
+		if match, _ := active.Match(app); match {
+			return false, "maintenance window active"
+		}
+	}
+	if policy.Automated.Prune && proj.GetAnnotations()[common.AnnotationKeyAllowAutomatedPrune] == "false" {
+		return false, fmt.Sprintf("automated pruning is not permitted in project '%s'", proj.Name)
+	}
+	if app.Status.Sync.Status != argoappv1.SyncStatusCodeOutOfSync {
+		return false, fmt.Sprintf("application status is %s", app.Status.Sync.Status)
+	}
+	return true, ""
+}
+
+// AppsAffectedByRepoChange returns the names of the applications which track the given repository and whose source
+// path contains one of the changed paths. Applications deployed from the repository root are always affected.
+func AppsAffectedByRepoChange(apps []argoappv1.Application, repoURL string, changedPaths []string) []string {
+	affected := make([]string, 0)
+	for _, app := range apps {
+		if !git.SameURL(app.Spec.Source.RepoURL, repoURL) {
+			continue
+		}
+		for _, changedPath := range changedPaths {
+			if pathContains(app.Spec.Source.Path, changedPath) {
+				affected = append(affected, app.Name)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+// pathContains returns true if the repository path is equal to or a parent of the given file path
+func pathContains(dir string, path string) bool {
+	dir = filepath.Clean(dir)
+	if dir == "." || dir == "/" {
+		return true
+	}
+	path = filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// ValidatePluginSource verifies the config management plugin referenced by the source is one of the registered plugins
+func ValidatePluginSource(source *argoappv1.ApplicationSource, registered map[string]bool) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if source.Plugin == nil || source.Plugin.Name == "" {
+		return conditions
+	}
+	if !registered[source.Plugin.Name] {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("config management plugin '%s' is not registered", source.Plugin.Name),
+		})
+	}
+	return conditions
+}
+
+// ValidateIgnoreDifferences verifies the JSON pointers of the ignored differences of the application are well formed,
+// since malformed pointers never match any field
+func ValidateIgnoreDifferences(app *argoappv1.Application) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	for i, ignore := range app.Spec.IgnoreDifferences {
+		for _, pointer := range ignore.JSONPointers {
+			if !isValidJSONPointer(pointer) {
+				conditions = append(conditions, argoappv1.ApplicationCondition{
+					Type:    argoappv1.ApplicationConditionInvalidSpecError,
+					Message: fmt.Sprintf("spec.ignoreDifferences[%d] has a malformed JSON pointer '%s'", i, pointer),
+				})
+			}
+		}
+	}
+	return conditions
+}
+
+// isValidJSONPointer returns true if the pointer is a syntactically valid RFC 6901 JSON pointer
+func isValidJSONPointer(pointer string) bool {
+	if pointer == "" {
+		return true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return false
+	}
+	for i := 0; i < len(pointer); i++ {
+		if pointer[i] == '~' && (i+1 == len(pointer) || (pointer[i+1] != '0' && pointer[i+1] != '1')) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateRevisionExists verifies the branch or tag tracked by the application is one of the refs available in the
+// repository. Commit SHAs, HEAD and Helm chart versions are not checked.
+func ValidateRevisionExists(spec *argoappv1.ApplicationSpec, availableRefs []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	revision := spec.Source.TargetRevision
+	if spec.Source.Chart != "" || revision == "" || revision == "HEAD" || ClassifyRevision(revision) == RevisionTypeSHA {
+		return conditions
+	}
+	for _, ref := range availableRefs {
+		if shortRefName(ref) == shortRefName(revision) {
+			return conditions
+		}
+	}
+	conditions = append(conditions, argoappv1.ApplicationCondition{
+		Type:    argoappv1.ApplicationConditionInvalidSpecError,
+		Message: fmt.Sprintf("application revision '%s' does not exist in repository %s", revision, spec.Source.RepoURL),
+	})
+	return conditions
+}
+
+// shortRefName strips the refs/heads/ or refs/tags/ prefix of a fully qualified git reference
+func shortRefName(ref string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+}
+
+// SortConditions returns a copy of the conditions sorted by type and message, so that the status of an application
+// does not change when the same conditions are reported in a different order
+func SortConditions(conditions []argoappv1.ApplicationCondition) []argoappv1.ApplicationCondition {
+	sorted := make([]argoappv1.ApplicationCondition, len(conditions))
+	copy(sorted, conditions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Message < sorted[j].Message
+	})
+	return sorted
+}
+
+// HasConflictingSyncState returns true if automated sync is enabled while a manual operation has not completed yet.
+// Operations do not record who initiated them, so an operation is considered manual if it uses options the automated
+// sync never sets: a rollback source, local manifests, a dry run, a sync strategy or a prune option which differs from
+// the automated sync policy.
+func HasConflictingSyncState(app *argoappv1.Application) bool {
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil || app.Operation == nil || app.Operation.Sync == nil {
+		return false
+	}
+	if state := app.Status.OperationState; state != nil && state.Phase.Completed() {
+		return false
+	}
+	sync := app.Operation.Sync
+	return sync.Source != nil || len(sync.Manifests) > 0 || sync.DryRun || sync.SyncStrategy != nil || sync.Prune != policy.Automated.Prune
+}
+
+// ValidateSyncPolicyCoherence verifies that an application with an automated sync policy does not request an operation
+// using options which only make sense for a manual sync: local manifests, a dry run or a rollback to another source.
+// The automated sync would immediately revert the result of such an operation.
+func ValidateSyncPolicyCoherence(app *argoappv1.Application) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil || app.Operation == nil || app.Operation.Sync == nil {
+		return conditions
+	}
+	sync := app.Operation.Sync
+	manualOnly := make([]string, 0)
+	if len(sync.Manifests) > 0 {
+		manualOnly = append(manualOnly, "manifests")
+	}
+	if sync.DryRun {
+		manualOnly = append(manualOnly, "dryRun")
+	}
+	if sync.Source != nil {
+		manualOnly = append(manualOnly, "source")
+	}
+	for _, field := range manualOnly {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("operation.sync.%s is only supported for manual sync but application '%s' has an automated sync policy", field, app.Name),
+		})
+	}
+	return conditions
+}
+
+// SyncOptions are the options of the sync-options annotation, e.g. Prune=false
+type SyncOptions []string
+
+// ValidateSyncOptions verifies each sync option has the Name=value form and that no option is set to conflicting values
+func ValidateSyncOptions(opts SyncOptions) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	seen := make(map[string]string)
+	for _, opt := range opts {
+		opt = strings.TrimSpace(opt)
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("sync option '%s' must have the form Name=value", opt),
+			})
+			continue
+		}
+		previous, ok := seen[parts[0]]
+		if !ok {
+			seen[parts[0]] = opt
+		} else if previous != opt {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("sync options '%s' and '%s' conflict", previous, opt),
+			})
+		}
+	}
+	return conditions
+}
+
+// ResourceTreeDepth returns the number of levels of the resource tree described by the given parent to children map.
+// Cycles are guarded against: a resource which is already on the current path is not visited again.
+func ResourceTreeDepth(nodes map[argoappv1.ResourceRef][]argoappv1.ResourceRef) int {
+	depths := make(map[argoappv1.ResourceRef]int)
+	visiting := make(map[argoappv1.ResourceRef]bool)
+	var depthOf func(ref argoappv1.ResourceRef) int
+	depthOf = func(ref argoappv1.ResourceRef) int {
+		if depth, ok := depths[ref]; ok {
+			return depth
+		}
+		if visiting[ref] {
+			return 0
+		}
+		visiting[ref] = true
+		depth := 0
+		for _, child := range nodes[ref] {
+			if childDepth := depthOf(child); childDepth > depth {
+				depth = childDepth
+			}
+		}
+		visiting[ref] = false
+		depths[ref] = depth + 1
+		return depth + 1
+	}
+	maxDepth := 0
+	for ref := range nodes {
+		if depth := depthOf(ref); depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return maxDepth
+}
+
+// ValidateAppNamespacePermitted verifies the namespace of the application matches one of the source namespace globs
+// of its project. Projects which do not declare source namespaces do not restrict the namespace of their applications.
+func ValidateAppNamespacePermitted(app *argoappv1.Application, proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	sourceNamespaces, ok := proj.GetAnnotations()[common.AnnotationKeySourceNamespaces]
+	if !ok {
+		return conditions
+	}
+	for _, namespace := range strings.Split(sourceNamespaces, ",") {
+		if globMatch(strings.TrimSpace(namespace), app.Namespace) {
+			return conditions
+		}
+	}
+	conditions = append(conditions, argoappv1.ApplicationCondition{
+		Type:    argoappv1.ApplicationConditionInvalidSpecError,
+		Message: fmt.Sprintf("application namespace '%s' is not permitted in project '%s'", app.Namespace, proj.Name),
+	})
+	return conditions
+}
+
+// EffectiveHealthOverrides returns the custom health check Lua scripts keyed by group/kind which apply to the
+// applications of the project. The global scripts are merged with the ones declared by the project, which take
+// precedence. Project overrides which cannot be parsed are ignored.
+func EffectiveHealthOverrides(proj *argoappv1.AppProject, global map[string]string) map[string]string {
+	overrides := make(map[string]string)
+	for key, script := range global {
+		overrides[key] = script
+	}
+	value, ok := proj.GetAnnotations()[common.AnnotationKeyHealthOverrides]
+	if !ok {
+		return overrides
+	}
+	projectOverrides := make(map[string]string)
+	if err := yaml.Unmarshal([]byte(value), &projectOverrides); err != nil {
+		log.Warnf("Invalid health overrides of project '%s': %v", proj.Name, err)
+		return overrides
+	}
+	for key, script := range projectOverrides {
+		overrides[key] = script
+	}
+	return overrides
+}
+
+// DetectAppDependencyCycle returns a dependency cycle between applications, given the names of the applications each
+// application depends on. The returned path starts and ends with the same application. Applications are visited in
+// sorted order so the reported cycle is deterministic.
+func DetectAppDependencyCycle(edges map[string][]string) ([]string, bool) {
+	names := make([]string, 0, len(edges))
+	for name := range edges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	visited := make(map[string]bool)
+	onPath := make(map[string]int)
+	path := make([]string, 0)
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i, ok := onPath[name]; ok {
+			return append(append([]string{}, path[i:]...), name)
+		}
+		if visited[name] {
+			return nil
+		}
+		visited[name] = true
+		onPath[name] = len(path)
+		path = append(path, name)
+		for _, dependency := range edges[name] {
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, name)
+		return nil
+	}
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			return cycle, true
+		}
+	}
+	return nil, false
+}
+
+// EffectiveSyncTimeout returns the sync timeout read from the sync timeout annotation of the application, or else of
+// the project. The fallback is returned if neither sets a valid positive duration.
+func EffectiveSyncTimeout(app *argoappv1.Application, proj *argoappv1.AppProject, fallback time.Duration) time.Duration {
+	if timeout, ok := syncTimeout(app.GetAnnotations(), "application", app.Name); ok {
+		return timeout
+	}
+	if timeout, ok := syncTimeout(proj.GetAnnotations(), "project", proj.Name); ok {
+		return timeout
+	}
+	return fallback
+}
+
+// syncTimeout parses the sync timeout annotation of an application or project
+func syncTimeout(annotations map[string]string, kind string, name string) (time.Duration, bool) {
+	value, ok := annotations[common.AnnotationKeySyncTimeout]
+	if !ok {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Warnf("Invalid sync timeout '%s' of %s '%s'", value, kind, name)
+		return 0, false
+	}
+	return timeout, true
+}
+
+// ValidateNoSelfReference reports an app-of-apps which renders its own Application manifest. Children without a
+// namespace are created in the destination namespace of the parent.
+func ValidateNoSelfReference(app *argoappv1.Application, children []argoappv1.Application) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	for _, child := range children {
+		namespace := child.Namespace
+		if namespace == "" {
+			namespace = app.Spec.Destination.Namespace
+		}
+		if child.Name == app.Name && namespace == app.Namespace {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("application '%s' includes its own manifest as a child application", app.Name),
+			})
+			break
+		}
+	}
+	return conditions
+}
+
+// AppProjectEquals returns true if the specs of the projects are equal once the ordering of their source repositories,
+// destinations, roles, role policies and groups, and resource lists is normalized
+func AppProjectEquals(a, b *argoappv1.AppProject) bool {
+	return reflect.DeepEqual(normalizeAppProjectSpec(a.Spec), normalizeAppProjectSpec(b.Spec))
+}
+
+// normalizeAppProjectSpec returns a copy of the project spec with its lists sorted and empty lists set to nil
+func normalizeAppProjectSpec(spec argoappv1.AppProjectSpec) *argoappv1.AppProjectSpec {
+	normalized := spec.DeepCopy()
+	normalized.SourceRepos = sortedStrings(normalized.SourceRepos)
+	if len(normalized.Destinations) == 0 {
+		normalized.Destinations = nil
+	}
+	sort.Slice(normalized.Destinations, func(i, j int) bool {
+		if normalized.Destinations[i].Server != normalized.Destinations[j].Server {
+			return normalized.Destinations[i].Server < normalized.Destinations[j].Server
+		}
+		return normalized.Destinations[i].Namespace < normalized.Destinations[j].Namespace
+	})
+	if len(normalized.Roles) == 0 {
+		normalized.Roles = nil
+	}
+	for i := range normalized.Roles {
+		normalized.Roles[i].Policies = sortedStrings(normalized.Roles[i].Policies)
+		normalized.Roles[i].Groups = sortedStrings(normalized.Roles[i].Groups)
+	}
+	sort.Slice(normalized.Roles, func(i, j int) bool {
+		return normalized.Roles[i].Name < normalized.Roles[j].Name
+	})
+	normalized.ClusterResourceWhitelist = sortedGroupKinds(normalized.ClusterResourceWhitelist)
+	normalized.NamespaceResourceBlacklist = sortedGroupKinds(normalized.NamespaceResourceBlacklist)
+	return normalized
+}
+
+// sortedStrings sorts the given strings in place, returning nil if there are none
+func sortedStrings(items []string) []string {
+	if len(items) == 0 {
+		return nil
+	}
+	sort.Strings(items)
+	return items
+}
+
+// sortedGroupKinds sorts the given group kinds in place, returning nil if there are none
+func sortedGroupKinds(items []metav1.GroupKind) []metav1.GroupKind {
+	if len(items) == 0 {
+		return nil
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Group != items[j].Group {
+			return items[i].Group < items[j].Group
+		}
+		return items[i].Kind < items[j].Kind
+	})
+	return items
+}
+
+// ProjectDeploymentClusters returns the sorted, distinct servers the applications deploy to. Destination servers are
+// resolved to the server URL of the matching configured cluster, ignoring trailing slashes; servers which are not
+// configured are returned as is.
+func ProjectDeploymentClusters(ctx context.Context, apps []argoappv1.Application, clusters ClusterLister) ([]string, error) {
+	clusterList, err := clusters.ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]string)
+	for _, cluster := range clusterList.Items {
+		configured[strings.TrimRight(cluster.Server, "/")] = cluster.Server
+	}
+	servers := make(map[string]bool)
+	for _, app := range apps {
+		server := app.Spec.Destination.Server
+		if server == "" {
+			continue
+		}
+		if resolved, ok := configured[strings.TrimRight(server, "/")]; ok {
+			server = resolved
+		}
+		servers[server] = true
+	}
+	distinct := make([]string, 0, len(servers))
+	for server := range servers {
+		distinct = append(distinct, server)
+	}
+	sort.Strings(distinct)
+	return distinct, nil
+}
+
+// EffectiveResourceExclusions returns the resources excluded from the sync of the application: the namespace resource
+// blacklist of the project, the global resource exclusions which apply to the destination cluster and the resources
+// listed in the resource-exclusions annotation of the application. Global exclusions without API groups or kinds are
+// returned with the '*' wildcard. Duplicates are removed, keeping the first occurrence.
+func EffectiveResourceExclusions(app *argoappv1.Application, proj *argoappv1.AppProject, global []settings.FilteredResource) []metav1.GroupKind {
+	exclusions := make([]metav1.GroupKind, 0)
+	seen := make(map[metav1.GroupKind]bool)
+	add := func(gk metav1.GroupKind) {
+		if !seen[gk] {
+			seen[gk] = true
+			exclusions = append(exclusions, gk)
+		}
+	}
+	for _, gk := range proj.Spec.NamespaceResourceBlacklist {
+		add(gk)
+	}
+	for _, excluded := range global {
+		if !excluded.MatchCluster(app.Spec.Destination.Server) {
+			continue
+		}
+		groups := excluded.APIGroups
+		if len(groups) == 0 {
+			groups = []string{"*"}
+		}
+		kinds := excluded.Kinds
+		if len(kinds) == 0 {
+			kinds = []string{"*"}
+		}
+		for _, group := range groups {
+			for _, kind := range kinds {
+				add(metav1.GroupKind{Group: group, Kind: kind})
+			}
+		}
+	}
+	for _, gk := range parseGroupKinds(app.GetAnnotations()[common.AnnotationKeyResourceExclusions]) {
+		add(gk)
+	}
+	return exclusions
+}
+
+// parseGroupKinds parses a comma separated list of group/kind resources. Resources of the core group may omit the group.
+func parseGroupKinds(value string) []metav1.GroupKind {
+	groupKinds := make([]metav1.GroupKind, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		gk := metav1.GroupKind{Kind: item}
+		if i := strings.LastIndex(item, "/"); i >= 0 {
+			gk = metav1.GroupKind{Group: item[:i], Kind: item[i+1:]}
+		}
+		groupKinds = append(groupKinds, gk)
+	}
+	return groupKinds
+}
+
+// ProjectChanged returns true if the project of the application differs from the one recorded in the
+// reconciled-project annotation during the last reconciliation, in which case the application must be validated
+// against its new project. Applications without a recorded project are not considered changed.
+func ProjectChanged(app *argoappv1.Application) bool {
+	reconciled, ok := app.GetAnnotations()[common.AnnotationKeyReconciledProject]
+	return ok && reconciled != app.Spec.GetProject()
+}
+
+// ValidatePrunePolicy warns about every managed resource whose kind is listed in the prune-protected-kinds annotation of
+// the project when the application enables automated pruning, since such resources must never be pruned
+func ValidatePrunePolicy(app *argoappv1.Application, proj *argoappv1.AppProject, managed []argoappv1.ResourceRef) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	policy := app.Spec.SyncPolicy
+	if policy == nil || policy.Automated == nil || !policy.Automated.Prune {
+		return conditions
+	}
+	protected := parseGroupKinds(proj.GetAnnotations()[common.AnnotationKeyPruneProtectedKinds])
+	for _, res := range managed {
+		if !matchesGroupKind(res.Group, res.Kind, protected) {
+			continue
+		}
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionPruneProtectedWarning,
+			Message: fmt.Sprintf("automated pruning may delete %s '%s' which is protected from pruning by project '%s'", res.Kind, res.Name, proj.Name),
+		})
+	}
+	return conditions
+}
+
+// matchesGroupKind returns whether the group and kind match one of the group/kind patterns
+func matchesGroupKind(group string, kind string, patterns []metav1.GroupKind) bool {
+	for _, pattern := range patterns {
+		if ok, err := filepath.Match(pattern.Kind, kind); !ok || err != nil {
+			continue
+		}
+		if ok, err := filepath.Match(pattern.Group, group); ok && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceWithLabels is a managed resource along with its labels
+type ResourceWithLabels struct {
+	Ref    argoappv1.ResourceRef
+	Labels map[string]string
+}
+
+// SyncResourcesFromSelector returns the managed resources whose labels match the selector as the resources of a
+// selective sync, in the order of the managed resources. Sync resources are not namespaced, so resources which only
+// differ by namespace are returned once.
+func SyncResourcesFromSelector(managed []ResourceWithLabels, selector labels.Selector) []argoappv1.SyncOperationResource {
+	resources := make([]argoappv1.SyncOperationResource, 0)
+	seen := make(map[argoappv1.SyncOperationResource]bool)
+	for _, res := range managed {
+		if !selector.Matches(labels.Set(res.Labels)) {
+			continue
+		}
+		syncResource := argoappv1.SyncOperationResource{Group: res.Ref.Group, Kind: res.Ref.Kind, Name: res.Ref.Name}
+		if !seen[syncResource] {
+			seen[syncResource] = true
+			resources = append(resources, syncResource)
+		}
+	}
+	return resources
+}
+
+// ValidateDestinationLock verifies that the destination of the application still matches the destination it was locked
+// to. Applications without a locked destination are not validated.
+func ValidateDestinationLock(app *argoappv1.Application, lockedDest *argoappv1.ApplicationDestination) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if lockedDest == nil {
+		return conditions
+	}
+	dest := app.Spec.Destination
+	if dest.Server != lockedDest.Server || dest.Namespace != lockedDest.Namespace {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application destination %v differs from the locked destination %v", dest, *lockedDest),
+		})
+	}
+	return conditions
+}
+
+// EffectiveResourceAnnotations returns the annotations to apply to every resource managed by the application. The
+// annotations requested by the resource-annotations annotation of the project are merged with the ones requested by
+// the application, which take precedence. Annotations which cannot be parsed are ignored.
+func EffectiveResourceAnnotations(app *argoappv1.Application, proj *argoappv1.AppProject) map[string]string {
+	annotations := make(map[string]string)
+	for _, obj := range []metav1.Object{proj, app} {
+		value, ok := obj.GetAnnotations()[common.AnnotationKeyResourceAnnotations]
+		if !ok {
+			continue
+		}
+		requested := make(map[string]string)
+		if err := yaml.Unmarshal([]byte(value), &requested); err != nil {
+			log.Warnf("Invalid resource annotations of '%s': %v", obj.GetName(), err)
+			continue
+		}
+		for key, value := range requested {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// ValidateAppNameConvention verifies the application name matches the regular expression held by the app-name-pattern
+// annotation of its project. Projects without a pattern do not impose a naming convention.
+func ValidateAppNameConvention(app *argoappv1.Application, proj *argoappv1.AppProject) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	pattern, ok := proj.GetAnnotations()[common.AnnotationKeyAppNamePattern]
+	if !ok {
+		return conditions
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application name pattern '%s' of project '%s' is invalid: %v", pattern, proj.Name, err),
+		})
+		return conditions
+	}
+	if !re.MatchString(app.Name) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("application name '%s' does not match the pattern '%s' required by project '%s'", app.Name, pattern, proj.Name),
+		})
+	}
+	return conditions
+}
+
+// TargetRevisionsByRepo returns the sorted, distinct target revisions tracked by the applications, keyed by normalized
+// repository URL. Git repository URLs are normalized with git.NormalizeGitURL and Helm repository URLs the same way as
+// DistinctHelmRepos does.
+func TargetRevisionsByRepo(apps []argoappv1.Application) map[string][]string {
+	revisions := make(map[string]map[string]bool)
+	for _, app := range apps {
+		source := app.Spec.Source
+		if source.RepoURL == "" {
+			continue
+		}
+		repo := git.NormalizeGitURL(source.RepoURL)
+		if source.Chart != "" {
+			repo = normalizeHelmRepoURL(source.RepoURL)
+		}
+		if revisions[repo] == nil {
+			revisions[repo] = make(map[string]bool)
+		}
+		revisions[repo][source.TargetRevision] = true
+	}
+	byRepo := make(map[string][]string, len(revisions))
+	for repo, set := range revisions {
+		distinct := make([]string, 0, len(set))
+		for revision := range set {
+			distinct = append(distinct, revision)
+		}
+		sort.Strings(distinct)
+		byRepo[repo] = distinct
+	}
+	return byRepo
+}
@@ -2,8 +2,10 @@ package argo
 
 import (
 	"encoding/json"
+	"sort"
 	"strings"
 
+	"github.com/argoproj/argo-cd/common"
 	"github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
 	"github.com/argoproj/argo-cd/util/diff"
 
@@ -25,33 +27,25 @@ type normalizer struct {
 	patches []normalizerPatch
 }
 
+// ignoreDiffTarget identifies the resources matched by an ignored differences entry
+type ignoreDiffTarget struct {
+	group     string
+	kind      string
+	name      string
+	namespace string
+}
+
 type overrideIgnoreDiff struct {
 	JSONPointers []string `yaml:"jsonPointers"`
 }
 
 // NewDiffNormalizer creates diff normalizer which removes ignored fields according to given application spec and resource overrides
 func NewDiffNormalizer(ignore []v1alpha1.ResourceIgnoreDifferences, overrides map[string]v1alpha1.ResourceOverride) (diff.Normalizer, error) {
-	for key, override := range overrides {
-		parts := strings.Split(key, "/")
-		if len(parts) < 2 {
-			continue
-		}
-		group := parts[0]
-		kind := parts[1]
-		if override.IgnoreDifferences != "" {
-			ignoreSettings := overrideIgnoreDiff{}
-			err := yaml.Unmarshal([]byte(override.IgnoreDifferences), &ignoreSettings)
-			if err != nil {
-				return nil, err
-			}
-
-			ignore = append(ignore, v1alpha1.ResourceIgnoreDifferences{
-				Group:        group,
-				Kind:         kind,
-				JSONPointers: ignoreSettings.JSONPointers,
-			})
-		}
+	overrideIgnore, err := overrideIgnoreDifferences(overrides)
+	if err != nil {
+		return nil, err
 	}
+	ignore = append(ignore, overrideIgnore...)
 	patches := make([]normalizerPatch, 0)
 	for i := range ignore {
 		for _, path := range ignore[i].JSONPointers {
@@ -75,6 +69,120 @@ func NewDiffNormalizer(ignore []v1alpha1.ResourceIgnoreDifferences, overrides ma
 	return &normalizer{patches: patches}, nil
 }
 
+// EffectiveIgnoreDifferences returns the ignored differences of the application merged with the ones configured in
+// the resource overrides. Entries which match the same resources are combined and duplicate JSON pointers are dropped.
+func EffectiveIgnoreDifferences(app *v1alpha1.Application, overrides map[string]v1alpha1.ResourceOverride) ([]v1alpha1.ResourceIgnoreDifferences, error) {
+	overrideIgnore, err := overrideIgnoreDifferences(overrides)
+	if err != nil {
+		return nil, err
+	}
+	effective := make([]v1alpha1.ResourceIgnoreDifferences, 0)
+	indexes := make(map[ignoreDiffTarget]int)
+	pointers := make(map[ignoreDiffTarget]map[string]bool)
+	for _, ignore := range append(append([]v1alpha1.ResourceIgnoreDifferences{}, app.Spec.IgnoreDifferences...), overrideIgnore...) {
+		target := ignoreDiffTarget{group: ignore.Group, kind: ignore.Kind, name: ignore.Name, namespace: ignore.Namespace}
+		i, ok := indexes[target]
+		if !ok {
+			i = len(effective)
+			indexes[target] = i
+			pointers[target] = make(map[string]bool)
+			effective = append(effective, v1alpha1.ResourceIgnoreDifferences{Group: ignore.Group, Kind: ignore.Kind, Name: ignore.Name, Namespace: ignore.Namespace})
+		}
+		for _, pointer := range ignore.JSONPointers {
+			if !pointers[target][pointer] {
+				pointers[target][pointer] = true
+				effective[i].JSONPointers = append(effective[i].JSONPointers, pointer)
+			}
+		}
+	}
+	return effective, nil
+}
+
+// DiffNormalizerConfig holds the configuration a diff normalizer is created from
+type DiffNormalizerConfig struct {
+	// IgnoreDifferences are the ignored differences declared by the application
+	IgnoreDifferences []v1alpha1.ResourceIgnoreDifferences
+	// Overrides are the resource overrides keyed by group/kind
+	Overrides map[string]v1alpha1.ResourceOverride
+}
+
+// EffectiveDiffNormalizers merges the diff normalization settings of the application, its project and the global
+// resource overrides. The ignored differences declared by the project replace the global ones of the same group/kind
+// while the ignored differences of the application are applied in addition to both. Project settings which cannot be
+// parsed are ignored.
+func EffectiveDiffNormalizers(app *v1alpha1.Application, proj *v1alpha1.AppProject, global map[string]v1alpha1.ResourceOverride) DiffNormalizerConfig {
+	config := DiffNormalizerConfig{
+		IgnoreDifferences: append([]v1alpha1.ResourceIgnoreDifferences{}, app.Spec.IgnoreDifferences...),
+		Overrides:         make(map[string]v1alpha1.ResourceOverride),
+	}
+	for key, override := range global {
+		config.Overrides[key] = override
+	}
+	value, ok := proj.GetAnnotations()[common.AnnotationKeyIgnoreDifferences]
+	if !ok {
+		return config
+	}
+	projectIgnore := make(map[string]overrideIgnoreDiff)
+	if err := yaml.Unmarshal([]byte(value), &projectIgnore); err != nil {
+		log.Warnf("Invalid ignored differences of project '%s': %v", proj.Name, err)
+		return config
+	}
+	for key, ignore := range projectIgnore {
+		data, err := yaml.Marshal(ignore)
+		if err != nil {
+			log.Warnf("Invalid ignored differences of project '%s' for '%s': %v", proj.Name, key, err)
+			continue
+		}
+		override := config.Overrides[key]
+		override.IgnoreDifferences = string(data)
+		config.Overrides[key] = override
+	}
+	return config
+}
+
+// overrideIgnoreDifferences parses the ignored differences of the resource overrides, sorted by group and kind
+func overrideIgnoreDifferences(overrides map[string]v1alpha1.ResourceOverride) ([]v1alpha1.ResourceIgnoreDifferences, error) {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ignore := make([]v1alpha1.ResourceIgnoreDifferences, 0)
+	for _, key := range keys {
+		override := overrides[key]
+		parts := strings.Split(key, "/")
+		if len(parts) < 2 {
+			continue
+		}
+		group := parts[0]
+		kind := parts[1]
+		if override.IgnoreDifferences != "" {
+			ignoreSettings := overrideIgnoreDiff{}
+			err := yaml.Unmarshal([]byte(override.IgnoreDifferences), &ignoreSettings)
+			if err != nil {
+				return nil, err
+			}
+
+			ignore = append(ignore, v1alpha1.ResourceIgnoreDifferences{
+				Group:        group,
+				Kind:         kind,
+				JSONPointers: ignoreSettings.JSONPointers,
+			})
+		}
+	}
+	return ignore, nil
+}
+
+// IgnoreDifferenceMatches returns true if the ignored differences rule applies to the referenced resource. The group
+// and kind must match unless the rule sets them to '*'; an empty or '*' name or namespace matches any resource. Note
+// that the diff normalizer does not support these wildcards and matches the group and kind of its rules exactly.
+func IgnoreDifferenceMatches(rule v1alpha1.ResourceIgnoreDifferences, ref v1alpha1.ResourceRef) bool {
+	return (rule.Group == "*" || rule.Group == ref.Group) &&
+		(rule.Kind == "*" || rule.Kind == ref.Kind) &&
+		(rule.Name == "" || rule.Name == "*" || rule.Name == ref.Name) &&
+		(rule.Namespace == "" || rule.Namespace == "*" || rule.Namespace == ref.Namespace)
+}
+
 // Normalize removes fields from supplied resource using json paths from matching items of specified resources ignored differences list
 func (n *normalizer) Normalize(un *unstructured.Unstructured) error {
 	matched := make([]normalizerPatch, 0)
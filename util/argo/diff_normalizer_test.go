@@ -5,8 +5,10 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
+	"github.com/argoproj/argo-cd/common"
 	"github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
 	"github.com/argoproj/argo-cd/test"
 	"github.com/argoproj/argo-cd/util/kube"
@@ -53,6 +55,25 @@ func TestNormalizeNoMatchedGroupKinds(t *testing.T) {
 	assert.True(t, hasSpec)
 }
 
+func TestNormalizeWildcardGroupKindNotMatched(t *testing.T) {
+	normalizer, err := NewDiffNormalizer([]v1alpha1.ResourceIgnoreDifferences{{
+		Group:        "*",
+		Kind:         "*",
+		JSONPointers: []string{"/spec"},
+	}}, make(map[string]v1alpha1.ResourceOverride))
+
+	assert.Nil(t, err)
+
+	deployment := kube.MustToUnstructured(test.DemoDeployment())
+
+	err = normalizer.Normalize(deployment)
+	assert.Nil(t, err)
+
+	_, hasSpec, err := unstructured.NestedMap(deployment.Object, "spec")
+	assert.Nil(t, err)
+	assert.True(t, hasSpec)
+}
+
 func TestNormalizeMatchedResourceOverrides(t *testing.T) {
 	normalizer, err := NewDiffNormalizer([]v1alpha1.ResourceIgnoreDifferences{}, map[string]v1alpha1.ResourceOverride{
 		"apps/Deployment": {
@@ -116,3 +137,88 @@ func TestNormalizeMissingJsonPointer(t *testing.T) {
 	err = normalizer.Normalize(&crd)
 	assert.NoError(t, err)
 }
+
+func TestEffectiveIgnoreDifferences(t *testing.T) {
+	overrides := map[string]v1alpha1.ResourceOverride{
+		"apps/Deployment": {IgnoreDifferences: `jsonPointers: ["/spec/replicas", "/spec/template/spec/containers"]`},
+		"admissionregistration.k8s.io/MutatingWebhookConfiguration": {IgnoreDifferences: `jsonPointers: ["/webhooks/0/clientConfig/caBundle"]`},
+	}
+	app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{IgnoreDifferences: []v1alpha1.ResourceIgnoreDifferences{
+		{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas"}},
+		{Kind: "Service", Name: "guestbook-ui", JSONPointers: []string{"/spec/clusterIP"}},
+	}}}
+
+	t.Run("AppOnly", func(t *testing.T) {
+		ignore, err := EffectiveIgnoreDifferences(app, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, app.Spec.IgnoreDifferences, ignore)
+	})
+	t.Run("OverridesOnly", func(t *testing.T) {
+		ignore, err := EffectiveIgnoreDifferences(&v1alpha1.Application{}, overrides)
+		assert.NoError(t, err)
+		assert.Equal(t, []v1alpha1.ResourceIgnoreDifferences{
+			{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration", JSONPointers: []string{"/webhooks/0/clientConfig/caBundle"}},
+			{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas", "/spec/template/spec/containers"}},
+		}, ignore)
+	})
+	t.Run("MergedWithOverlap", func(t *testing.T) {
+		ignore, err := EffectiveIgnoreDifferences(app, overrides)
+		assert.NoError(t, err)
+		assert.Equal(t, []v1alpha1.ResourceIgnoreDifferences{
+			{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas", "/spec/template/spec/containers"}},
+			{Kind: "Service", Name: "guestbook-ui", JSONPointers: []string{"/spec/clusterIP"}},
+			{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration", JSONPointers: []string{"/webhooks/0/clientConfig/caBundle"}},
+		}, ignore)
+	})
+	t.Run("InvalidOverride", func(t *testing.T) {
+		_, err := EffectiveIgnoreDifferences(app, map[string]v1alpha1.ResourceOverride{"apps/Deployment": {IgnoreDifferences: "jsonPointers: ["}})
+		assert.Error(t, err)
+	})
+}
+
+func TestIgnoreDifferenceMatches(t *testing.T) {
+	ref := v1alpha1.ResourceRef{Group: "apps", Kind: "Deployment", Namespace: "guestbook", Name: "guestbook-ui"}
+	t.Run("ExactMatch", func(t *testing.T) {
+		assert.True(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Group: "apps", Kind: "Deployment", Namespace: "guestbook", Name: "guestbook-ui"}, ref))
+	})
+	t.Run("KindOnlyMatch", func(t *testing.T) {
+		assert.True(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Group: "apps", Kind: "Deployment"}, ref))
+		assert.True(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Group: "*", Kind: "Deployment", Name: "*"}, ref))
+	})
+	t.Run("NonMatch", func(t *testing.T) {
+		assert.False(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Kind: "Deployment"}, ref))
+		assert.False(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Group: "apps", Kind: "Deployment", Name: "redis"}, ref))
+		assert.False(t, IgnoreDifferenceMatches(v1alpha1.ResourceIgnoreDifferences{Group: "apps", Kind: "StatefulSet"}, ref))
+	})
+}
+
+func TestEffectiveDiffNormalizers(t *testing.T) {
+	app := &v1alpha1.Application{Spec: v1alpha1.ApplicationSpec{IgnoreDifferences: []v1alpha1.ResourceIgnoreDifferences{
+		{Group: "apps", Kind: "Deployment", Name: "guestbook-ui", JSONPointers: []string{"/spec/template/metadata/annotations"}},
+	}}}
+	proj := &v1alpha1.AppProject{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	global := map[string]v1alpha1.ResourceOverride{
+		"apps/Deployment": {HealthLua: "return {}", IgnoreDifferences: "jsonPointers:\n- /spec/template\n"},
+		"admissionregistration.k8s.io/MutatingWebhookConfiguration": {IgnoreDifferences: "jsonPointers:\n- /webhooks/0/clientConfig/caBundle\n"},
+	}
+	t.Run("Layered", func(t *testing.T) {
+		config := EffectiveDiffNormalizers(app, proj, global)
+		assert.Equal(t, app.Spec.IgnoreDifferences, config.IgnoreDifferences)
+		assert.Equal(t, global, config.Overrides)
+	})
+	t.Run("ProjectOverridesGlobal", func(t *testing.T) {
+		proj := proj.DeepCopy()
+		proj.Annotations = map[string]string{common.AnnotationKeyIgnoreDifferences: "apps/Deployment:\n  jsonPointers: [/spec/replicas]\n/Service:\n  jsonPointers: [/spec/clusterIP]\n"}
+		config := EffectiveDiffNormalizers(app, proj, global)
+		assert.Equal(t, app.Spec.IgnoreDifferences, config.IgnoreDifferences)
+		assert.Equal(t, v1alpha1.ResourceOverride{HealthLua: "return {}", IgnoreDifferences: "jsonPointers:\n- /spec/replicas\n"}, config.Overrides["apps/Deployment"])
+		assert.Equal(t, v1alpha1.ResourceOverride{IgnoreDifferences: "jsonPointers:\n- /spec/clusterIP\n"}, config.Overrides["/Service"])
+		assert.Equal(t, global["admissionregistration.k8s.io/MutatingWebhookConfiguration"], config.Overrides["admissionregistration.k8s.io/MutatingWebhookConfiguration"])
+		assert.Equal(t, "jsonPointers:\n- /spec/template\n", global["apps/Deployment"].IgnoreDifferences)
+	})
+	t.Run("InvalidProjectSettings", func(t *testing.T) {
+		proj := proj.DeepCopy()
+		proj.Annotations = map[string]string{common.AnnotationKeyIgnoreDifferences: "not a map"}
+		assert.Equal(t, global, EffectiveDiffNormalizers(app, proj, global).Overrides)
+	})
+}
@@ -0,0 +1,1071 @@
+package argo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+	yamlv2 "gopkg.in/yaml.v2"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-cd/common"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/util/hash"
+)
+
+// HelmValuesOptions holds optional settings which control how the Helm values of an application are resolved
+type HelmValuesOptions struct {
+	// DefaultsTemplate is a Go template which is rendered with the application metadata and merged as the
+	// lowest priority values document
+	DefaultsTemplate string
+	// ProjectValuesFrom lists the values sources declared by the project of the application. They are merged above
+	// the defaults template and below the ValuesFrom sources of the application.
+	ProjectValuesFrom []HelmValuesFromSource
+	// ValuesFrom lists ConfigMap and Secret keys holding values documents, ordered from lowest to highest priority.
+	// They are merged above the defaults template and below the inline values of the application source.
+	ValuesFrom []HelmValuesFromSource
+	// AllowedNamespaces lists the namespaces other than the namespace of the application which values sources may read
+	// ConfigMaps and Secrets from. Resolution fails if a values source references any other namespace.
+	AllowedNamespaces []string
+	// CollectMissing reports every missing required ValuesFrom source in a single error instead of failing on the first one
+	CollectMissing bool
+	// Variables are substituted for ${NAME} references found in the string values of the values documents. The
+	// substitution happens after parsing, so a variable can never add or change keys.
+	Variables map[string]string
+	// StrictVariables fails the resolution if any values document references an undefined variable
+	StrictVariables bool
+	// StrictYAML fails the resolution if any values document contains duplicate mapping keys, which are otherwise
+	// silently accepted with the last value winning
+	StrictYAML bool
+	// SeedKey is the dot separated path under which a seed derived from the application name is injected, unless
+	// the values already set it. This makes charts using random functions seeded from values render reproducibly.
+	SeedKey string
+	// Transform is optionally applied to the merged values before they are serialized
+	Transform func(values map[string]interface{}) (map[string]interface{}, error)
+	// Cache optionally caches the parsed documents of the ConfigMaps and Secrets referenced by ValuesFrom across resolutions
+	Cache *HelmValuesCache
+	// OmitEmpty resolves values which set no key to an empty string instead of an empty YAML mapping ("{}\n")
+	OmitEmpty bool
+	// AuditSink optionally receives an entry for each values document merged, in merge order
+	AuditSink func(entry ValuesMergeAuditEntry)
+}
+
+// ValuesMergeAuditEntry records a values document merged while resolving the Helm values of an application
+type ValuesMergeAuditEntry struct {
+	// Source is the name of the source the document was read from
+	Source string
+	// Size is the size of the document in bytes
+	Size int
+	// OverriddenKeys are the sorted top-level keys of the document which were already set by a previous document
+	OverriddenKeys []string
+}
+
+// HelmValuesFromSource references a ConfigMap or Secret key which holds a Helm values document.
+// Exactly one of ConfigMapKeyRef or SecretKeyRef is expected to be set.
+type HelmValuesFromSource struct {
+	ConfigMapKeyRef *ValuesKeyRef
+	SecretKeyRef    *ValuesKeyRef
+}
+
+// ValuesKeyRef selects a key of a ConfigMap or Secret in the namespace of the application
+type ValuesKeyRef struct {
+	// Name is the name of the ConfigMap or Secret
+	Name string
+	// Namespace is the namespace of the ConfigMap or Secret. Defaults to the namespace of the application. Other
+	// namespaces must be listed in HelmValuesOptions.AllowedNamespaces.
+	Namespace string
+	// Key is the key holding the values document. It is rendered as a Go template with the application metadata,
+	// e.g. values-{{ index .Labels "env" }}.yaml selects the key matching the env label of the application.
+	Key string
+	// FallbackKey is used when the rendered Key does not exist
+	FallbackKey string
+	// Optional allows the ConfigMap, Secret or key to be missing
+	Optional bool
+	// Base64Decode decodes the base64 encoded content of the key before it is parsed
+	Base64Decode bool
+}
+
+// ValueSourceType is the kind of a source of Helm values
+type ValueSourceType string
+
+const (
+	ValueSourceTypeDefaults  ValueSourceType = "Defaults"
+	ValueSourceTypeFile      ValueSourceType = "File"
+	ValueSourceTypeConfigMap ValueSourceType = "ConfigMap"
+	ValueSourceTypeSecret    ValueSourceType = "Secret"
+	ValueSourceTypeInline    ValueSourceType = "Inline"
+)
+
+// ValueSourceDescriptor describes a single source of Helm values applied to an application
+type ValueSourceDescriptor struct {
+	Type ValueSourceType
+	// Name is the path of a value file or the name of a ConfigMap or Secret
+	Name string
+	// Key is the ConfigMap or Secret key holding the values
+	Key string
+	// Optional is true if the ConfigMap or Secret is allowed to be missing
+	Optional bool
+}
+
+var valuesVariableRx = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// valuesDocument is a single values YAML document along with the name of the source it was read from
+type valuesDocument struct {
+	source  string
+	content string
+	// parsed holds the parsed content if it is already known
+	parsed map[string]interface{}
+}
+
+// valuesTemplateData is the data made available to the defaults values template
+type valuesTemplateData struct {
+	Name        string
+	Namespace   string
+	Project     string
+	Labels      map[string]string
+	Destination argoappv1.ApplicationDestination
+}
+
+// ResolveHelmValues merges all values documents which apply to the application into a single YAML document.
+// Documents are merged in order of increasing priority: the rendered defaults template, the project ValuesFrom
+// sources, the application ValuesFrom sources and finally the inline values of the application source.
+func ResolveHelmValues(kubeclientset kubernetes.Interface, app *argoappv1.Application, opts HelmValuesOptions) (string, error) {
+	return ResolveHelmValuesForSource(kubeclientset, app, &app.Spec.Source, opts)
+}
+
+// ResolveHelmValuesForSource merges the values documents which apply to the given source of the application into a
+// single YAML document. The documents are merged in the same order as ResolveHelmValues.
+func ResolveHelmValuesForSource(kubeclientset kubernetes.Interface, app *argoappv1.Application, source *argoappv1.ApplicationSource, opts HelmValuesOptions) (string, error) {
+	return resolveHelmValues(kubeclientset, app, source, opts, nil)
+}
+
+// HasResolvedValues returns whether the resolved Helm values of the application set at least one key
+func HasResolvedValues(kubeclientset kubernetes.Interface, app *argoappv1.Application, opts HelmValuesOptions) (bool, error) {
+	resolvedValues, err := ResolveHelmValues(kubeclientset, app, opts)
+	if err != nil {
+		return false, err
+	}
+	values, err := parseValues(resolvedValues)
+	if err != nil {
+		return false, err
+	}
+	return len(values) > 0, nil
+}
+
+// ValuesKeyPrecedence lists the sources which set a top-level values key, ordered from lowest to highest priority
+type ValuesKeyPrecedence struct {
+	Sources []string
+	// Winner is the source whose value takes precedence
+	Winner string
+}
+
+// ValuesPrecedenceReport maps each top-level values key to the sources which set it
+type ValuesPrecedenceReport map[string]*ValuesKeyPrecedence
+
+// ResolveHelmValuesWithReport resolves the Helm values like ResolveHelmValues and additionally reports, for each
+// top-level key, every source which set it along with the source which won
+func ResolveHelmValuesWithReport(kubeclientset kubernetes.Interface, app *argoappv1.Application, opts HelmValuesOptions) (string, ValuesPrecedenceReport, error) {
+	report := make(ValuesPrecedenceReport)
+	values, err := resolveHelmValues(kubeclientset, app, &app.Spec.Source, opts, report)
+	if err != nil {
+		return "", nil, err
+	}
+	return values, report, nil
+}
+
+// ResolvedValuesSizeBreakdown returns the number of bytes each values document contributes to the resolved Helm
+// values of the application, keyed by the name of its source. It helps finding the source of oversized values.
+func ResolvedValuesSizeBreakdown(kubeclientset kubernetes.Interface, app *argoappv1.Application, opts HelmValuesOptions) (map[string]int, error) {
+	documents, err := getValuesDocuments(kubeclientset, app, &app.Spec.Source, opts)
+	if err != nil {
+		return nil, err
+	}
+	documents, err = expandValuesVariables(documents, opts)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int)
+	for _, doc := range documents {
+		sizes[doc.source] += len(doc.content)
+	}
+	return sizes, nil
+}
+
+// resolveHelmValues merges the values documents of the source, recording the sources of each top-level key in the
+// report unless it is nil
+func resolveHelmValues(kubeclientset kubernetes.Interface, app *argoappv1.Application, source *argoappv1.ApplicationSource, opts HelmValuesOptions, report ValuesPrecedenceReport) (string, error) {
+	documents, err := getValuesDocuments(kubeclientset, app, source, opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.StrictYAML {
+		for _, doc := range documents {
+			var strict interface{}
+			if err := yamlv2.UnmarshalStrict([]byte(doc.content), &strict); err != nil {
+				return "", fmt.Errorf("failed to parse values from %s: %v", doc.source, err)
+			}
+		}
+	}
+	documents, err = expandValuesVariables(documents, opts)
+	if err != nil {
+		return "", err
+	}
+	merged := make(map[string]interface{})
+	for _, doc := range documents {
+		values := doc.parsed
+		if values == nil {
+			if values, err = parseValues(doc.content); err != nil {
+				return "", fmt.Errorf("failed to parse values from %s: %v", doc.source, err)
+			}
+		}
+		if opts.AuditSink != nil {
+			overridden := make([]string, 0)
+			for key := range values {
+				if _, ok := merged[key]; ok {
+					overridden = append(overridden, key)
+				}
+			}
+			sort.Strings(overridden)
+			opts.AuditSink(ValuesMergeAuditEntry{Source: doc.source, Size: len(doc.content), OverriddenKeys: overridden})
+		}
+		if report != nil {
+			for key := range values {
+				precedence, ok := report[key]
+				if !ok {
+					precedence = &ValuesKeyPrecedence{}
+					report[key] = precedence
+				}
+				precedence.Sources = append(precedence.Sources, doc.source)
+				precedence.Winner = doc.source
+			}
+		}
+		merged = mergeValues(merged, values)
+	}
+	if opts.SeedKey != "" {
+		if _, ok := lookupValue(merged, opts.SeedKey); !ok {
+			if err := setValue(merged, opts.SeedKey, hash.FNVa(fmt.Sprintf("%s/%s", app.Namespace, app.Name))); err != nil {
+				return "", fmt.Errorf("failed to inject seed under '%s': %v", opts.SeedKey, err)
+			}
+		}
+	}
+	if opts.Transform != nil {
+		if merged, err = opts.Transform(merged); err != nil {
+			return "", fmt.Errorf("failed to transform values: %v", err)
+		}
+	}
+	if opts.OmitEmpty && len(merged) == 0 {
+		return "", nil
+	}
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// EffectiveValueSources returns the sources of the Helm values applied to the application, ordered from lowest to
+// highest priority: the defaults template, the value files of the chart, the project and application ValuesFrom
+// sources and the inline values.
+func EffectiveValueSources(spec *argoappv1.ApplicationSpec, opts HelmValuesOptions) []ValueSourceDescriptor {
+	sources := make([]ValueSourceDescriptor, 0)
+	if opts.DefaultsTemplate != "" {
+		sources = append(sources, ValueSourceDescriptor{Type: ValueSourceTypeDefaults})
+	}
+	helm := spec.Source.Helm
+	if helm != nil {
+		for _, path := range helm.ValueFiles {
+			sources = append(sources, ValueSourceDescriptor{Type: ValueSourceTypeFile, Name: path})
+		}
+	}
+	for _, from := range valuesFromSources(opts) {
+		switch {
+		case from.ConfigMapKeyRef != nil:
+			ref := from.ConfigMapKeyRef
+			sources = append(sources, ValueSourceDescriptor{Type: ValueSourceTypeConfigMap, Name: ref.Name, Key: ref.Key, Optional: ref.Optional})
+		case from.SecretKeyRef != nil:
+			ref := from.SecretKeyRef
+			sources = append(sources, ValueSourceDescriptor{Type: ValueSourceTypeSecret, Name: ref.Name, Key: ref.Key, Optional: ref.Optional})
+		}
+	}
+	if helm != nil && helm.Values != "" {
+		sources = append(sources, ValueSourceDescriptor{Type: ValueSourceTypeInline})
+	}
+	return sources
+}
+
+// ValidateValueFileGlobs verifies each value file glob of the Helm source matches at least one of the given files.
+// The file paths are expected to be relative to the path of the application source, like the value files.
+func ValidateValueFileGlobs(spec *argoappv1.ApplicationSpec, fileList []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if spec.Source.Helm == nil {
+		return conditions
+	}
+	for _, pattern := range spec.Source.Helm.ValueFiles {
+		if !strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+		matched := false
+		for _, file := range fileList {
+			if ok, err := filepath.Match(pattern, file); err == nil && ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionUnmatchedValueFilesWarning,
+				Message: fmt.Sprintf("Helm value file glob '%s' does not match any file", pattern),
+			})
+		}
+	}
+	return conditions
+}
+
+// ValidateValuesFromNamespaces verifies the values sources only reference ConfigMaps and Secrets in the allowed
+// namespaces. References without a namespace use the namespace of the application, which is always allowed.
+func ValidateValuesFromNamespaces(app *argoappv1.Application, valuesFrom []HelmValuesFromSource, allowedNamespaces []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	allowed := map[string]bool{app.Namespace: true}
+	for _, namespace := range allowedNamespaces {
+		allowed[namespace] = true
+	}
+	for _, from := range valuesFrom {
+		kind, ref := "ConfigMap", from.ConfigMapKeyRef
+		if ref == nil {
+			kind, ref = "Secret", from.SecretKeyRef
+		}
+		if ref == nil {
+			continue
+		}
+		if namespace := valuesKeyRefNamespace(app, ref); !allowed[namespace] {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("values source %s '%s' references namespace '%s' which is not permitted", kind, ref.Name, namespace),
+			})
+		}
+	}
+	return conditions
+}
+
+// ResolvedValuesChecksum returns the checksum of the given resolved Helm values combined with the inline values of the
+// source, so that any edit of the inline values changes the checksum even if the resolved values stay the same
+func ResolvedValuesChecksum(resolvedValues string, source *argoappv1.ApplicationSource) string {
+	inlineValues := ""
+	if source != nil && source.Helm != nil {
+		inlineValues = source.Helm.Values
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(resolvedValues+"\x00"+inlineValues)))
+}
+
+// ManifestCacheKey returns a key identifying the manifests generated for the normalized spec and resolved Helm values.
+// The key changes whenever the spec or the checksum of the resolved values change.
+func ManifestCacheKey(spec *argoappv1.ApplicationSpec, resolvedValuesChecksum string) (string, error) {
+	specJSON, err := json.Marshal(NormalizeApplicationSpec(spec))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(append(specJSON, resolvedValuesChecksum...))), nil
+}
+
+// ManifestGenerationKey returns the key identifying the manifests generated by the repo server for the normalized spec
+// at the given resolved revision with the given resolved Helm values. It extends the ManifestCacheKey of the spec and
+// values with the revision, so changing any of the inputs changes the key.
+func ManifestGenerationKey(spec *argoappv1.ApplicationSpec, revision string, valuesChecksum string) (string, error) {
+	cacheKey, err := ManifestCacheKey(spec, valuesChecksum)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cacheKey+"\x00"+revision))), nil
+}
+
+// RefreshRequired returns true if the values checksum recorded on the application differs from the current one.
+// This indicates the external values of the application changed even though its spec did not.
+func RefreshRequired(app *argoappv1.Application, currentValuesChecksum string) bool {
+	return app.GetAnnotations()[common.AnnotationKeyValuesChecksum] != currentValuesChecksum
+}
+
+// ForbiddenRule forbids a Helm values key, optionally only when it holds a specific value
+type ForbiddenRule struct {
+	// Key is the dot separated path of the forbidden key, e.g. securityContext.privileged
+	Key string
+	// Value restricts the rule to a specific value of the key. An empty value forbids the key regardless of its value.
+	Value string
+}
+
+// ValidateForbiddenHelmValues verifies the resolved Helm values do not set any of the forbidden keys
+func ValidateForbiddenHelmValues(resolvedValues string, forbidden []ForbiddenRule) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	for _, rule := range forbidden {
+		value, ok := lookupValue(values, rule.Key)
+		if !ok {
+			continue
+		}
+		if rule.Value == "" {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("Helm values key '%s' is forbidden", rule.Key),
+			})
+		} else if fmt.Sprintf("%v", value) == rule.Value {
+			conditions = append(conditions, argoappv1.ApplicationCondition{
+				Type:    argoappv1.ApplicationConditionInvalidSpecError,
+				Message: fmt.Sprintf("Helm values key '%s' must not be set to '%s'", rule.Key, rule.Value),
+			})
+		}
+	}
+	return conditions
+}
+
+// ValidateHelmValuesAgainstSchema validates the resolved Helm values against the values.schema.json of the chart.
+// Each violation is reported as a separate condition which includes the path of the offending value.
+func ValidateHelmValuesAgainstSchema(resolvedValues string, schema []byte) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if len(schema) == 0 {
+		return conditions
+	}
+	var valuesSchema spec.Schema
+	if err := json.Unmarshal(schema, &valuesSchema); err != nil {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("unable to parse Helm values schema: %v", err),
+		})
+		return conditions
+	}
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	result := validate.NewSchemaValidator(&valuesSchema, nil, "", strfmt.Default).Validate(values)
+	for _, err := range result.Errors {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("Helm values do not match the chart schema: %v", err),
+		})
+	}
+	return conditions
+}
+
+// ValidateHelmValueParameterConsistency reports Helm parameters which override a key of the inline values with a
+// different value. Helm allows this, so the conditions are informational. Parameters using list indexes or escaped
+// dots are not checked.
+func ValidateHelmValueParameterConsistency(spec *argoappv1.ApplicationSpec) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	helm := spec.Source.Helm
+	if helm == nil || helm.Values == "" || len(helm.Parameters) == 0 {
+		return conditions
+	}
+	values, parseCondition := parseValuesCondition(helm.Values)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	for _, param := range helm.Parameters {
+		if strings.ContainsAny(param.Name, `[\`) {
+			continue
+		}
+		value, ok := lookupValue(values, param.Name)
+		if !ok || fmt.Sprintf("%v", value) == param.Value {
+			continue
+		}
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionHelmParameterOverrideInfo,
+			Message: fmt.Sprintf("Helm parameter '%s' overrides the inline value '%v' with '%s'", param.Name, value, param.Value),
+		})
+	}
+	return conditions
+}
+
+// ValidateNoTemplateInjection reports the paths of resolved Helm values which contain Go template markers, since they
+// could be evaluated if the chart passes the values through tpl. The conditions are warnings unless asError is set.
+func ValidateNoTemplateInjection(resolvedValues string, asError bool) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	conditionType := argoappv1.ApplicationConditionTemplateInjectionWarning
+	if asError {
+		conditionType = argoappv1.ApplicationConditionInvalidSpecError
+	}
+	for _, path := range findTemplateMarkers("", values) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    conditionType,
+			Message: fmt.Sprintf("Helm values key '%s' contains template markers", path),
+		})
+	}
+	return conditions
+}
+
+// ValidateNamespaceConsistency warns when the top-level namespace value of the resolved Helm values differs from the
+// destination namespace of the application
+func ValidateNamespaceConsistency(spec *argoappv1.ApplicationSpec, resolvedValues string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	namespace, ok := values["namespace"]
+	if !ok || namespace == nil || spec.Destination.Namespace == "" {
+		return conditions
+	}
+	if fmt.Sprintf("%v", namespace) != spec.Destination.Namespace {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionNamespaceMismatchWarning,
+			Message: fmt.Sprintf("Helm values namespace '%v' differs from the destination namespace '%s'", namespace, spec.Destination.Namespace),
+		})
+	}
+	return conditions
+}
+
+// ValidateHelmSecurityValues reports every key of the resolved Helm values, at any depth, which is named after one of the
+// forbidden security flags, such as hostNetwork or privileged, and is set to true
+func ValidateHelmSecurityValues(resolvedValues string, forbidden []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	flags := make(map[string]bool)
+	for _, flag := range forbidden {
+		flags[flag] = true
+	}
+	for _, path := range findEnabledFlags("", values, flags) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("Helm values key '%s' enables a forbidden security setting", path),
+		})
+	}
+	return conditions
+}
+
+// findEnabledFlags returns the sorted paths of the keys named after one of the flags which are set to true
+func findEnabledFlags(path string, value interface{}, flags map[string]bool) []string {
+	paths := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if enabled, ok := v[k].(bool); ok && enabled && flags[k] {
+				paths = append(paths, childPath)
+				continue
+			}
+			paths = append(paths, findEnabledFlags(childPath, v[k], flags)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			paths = append(paths, findEnabledFlags(fmt.Sprintf("%s[%d]", path, i), item, flags)...)
+		}
+	}
+	return paths
+}
+
+// ValidateValuesDepth reports every key of the resolved Helm values nested deeper than the maximum depth. Top-level
+// keys have a depth of 1 and list items count as a level. Keys nested under a reported key are not reported.
+func ValidateValuesDepth(resolvedValues string, maxDepth int) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	values, parseCondition := parseValuesCondition(resolvedValues)
+	if parseCondition != nil {
+		return append(conditions, *parseCondition)
+	}
+	for _, path := range findTooDeepValues("", values, 1, maxDepth) {
+		conditions = append(conditions, argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("Helm values key '%s' is nested deeper than the maximum depth of %d", path, maxDepth),
+		})
+	}
+	return conditions
+}
+
+// findTooDeepValues returns the sorted paths of the keys and list items which are nested deeper than the maximum depth
+func findTooDeepValues(path string, value interface{}, depth int, maxDepth int) []string {
+	paths := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if depth > maxDepth {
+				paths = append(paths, childPath)
+				continue
+			}
+			paths = append(paths, findTooDeepValues(childPath, v[k], depth+1, maxDepth)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			if depth > maxDepth {
+				paths = append(paths, childPath)
+				continue
+			}
+			paths = append(paths, findTooDeepValues(childPath, item, depth+1, maxDepth)...)
+		}
+	}
+	return paths
+}
+
+// ValidateReservedHelmParameters reports every Helm parameter of the application which targets one of the reserved
+// value paths, either the path itself, a key nested under it or one of its parents
+func ValidateReservedHelmParameters(spec *argoappv1.ApplicationSpec, reserved []string) []argoappv1.ApplicationCondition {
+	conditions := make([]argoappv1.ApplicationCondition, 0)
+	if spec.Source.Helm == nil {
+		return conditions
+	}
+	for _, param := range spec.Source.Helm.Parameters {
+		for _, path := range reserved {
+			if valuesPathOverlaps(param.Name, path) {
+				conditions = append(conditions, argoappv1.ApplicationCondition{
+					Type:    argoappv1.ApplicationConditionInvalidSpecError,
+					Message: fmt.Sprintf("Helm parameter '%s' overrides reserved values path '%s'", param.Name, path),
+				})
+				break
+			}
+		}
+	}
+	return conditions
+}
+
+// valuesPathOverlaps returns true if the values paths are equal or one is nested under the other
+func valuesPathOverlaps(a string, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a == b || strings.HasPrefix(b, a) && (b[len(a)] == '.' || b[len(a)] == '[')
+}
+
+// findTemplateMarkers returns the sorted paths of the string values containing '{{'
+func findTemplateMarkers(path string, value interface{}) []string {
+	paths := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			paths = append(paths, findTemplateMarkers(childPath, v[k])...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			paths = append(paths, findTemplateMarkers(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	case string:
+		if strings.Contains(v, "{{") {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// OverriddenValues returns the resolved Helm values which differ from the default values of the chart, including keys
+// the chart does not define. Nested maps are compared key by key, so only the overridden leaves are returned.
+func OverriddenValues(resolvedValues string, chartDefaults string) (map[string]interface{}, error) {
+	values, err := parseValues(resolvedValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse resolved values: %v", err)
+	}
+	defaults, err := parseValues(chartDefaults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chart default values: %v", err)
+	}
+	return diffValues(values, defaults), nil
+}
+
+// diffValues returns the entries of values which differ from the given defaults
+func diffValues(values map[string]interface{}, defaults map[string]interface{}) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for key, value := range values {
+		defaultValue, ok := defaults[key]
+		if !ok {
+			diff[key] = value
+			continue
+		}
+		valueMap, isMap := value.(map[string]interface{})
+		defaultMap, isDefaultMap := defaultValue.(map[string]interface{})
+		if isMap && isDefaultMap {
+			if nested := diffValues(valueMap, defaultMap); len(nested) > 0 {
+				diff[key] = nested
+			}
+		} else if !reflect.DeepEqual(value, defaultValue) {
+			diff[key] = value
+		}
+	}
+	return diff
+}
+
+// parseValuesCondition parses a Helm values YAML document for validation, returning the condition to report instead of
+// the values if it cannot be parsed
+func parseValuesCondition(values string) (map[string]interface{}, *argoappv1.ApplicationCondition) {
+	parsed, err := parseValues(values)
+	if err != nil {
+		return nil, &argoappv1.ApplicationCondition{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: fmt.Sprintf("unable to parse Helm values: %v", err),
+		}
+	}
+	return parsed, nil
+}
+
+// parseValues parses a Helm values YAML document
+func parseValues(values string) (map[string]interface{}, error) {
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(values), &parsed); err != nil {
+		return nil, err
+	}
+	switch v := parsed.(type) {
+	case nil:
+		return make(map[string]interface{}), nil
+	case map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		return nil, fmt.Errorf("values must be a mapping at the top level, not a sequence")
+	default:
+		return nil, fmt.Errorf("values must be a mapping at the top level, not a scalar")
+	}
+}
+
+// lookupValue returns the value found at the given dot separated path of the values
+func lookupValue(values map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = values
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setValue sets the value at the given dot separated path of the values, creating missing intermediate maps. It fails
+// rather than replacing an intermediate value which is not a map.
+func setValue(values map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	current := values
+	for i, key := range keys[:len(keys)-1] {
+		existing, ok := current[key]
+		if !ok {
+			next := make(map[string]interface{})
+			current[key] = next
+			current = next
+			continue
+		}
+		next, ok := existing.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("value at '%s' is not a map", strings.Join(keys[:i+1], "."))
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+	return nil
+}
+
+// getValuesDocuments returns the values documents of the application source ordered from lowest to highest priority
+func getValuesDocuments(kubeclientset kubernetes.Interface, app *argoappv1.Application, source *argoappv1.ApplicationSource, opts HelmValuesOptions) ([]valuesDocument, error) {
+	documents := make([]valuesDocument, 0)
+	if opts.DefaultsTemplate != "" {
+		defaults, err := renderValuesTemplate("defaults", opts.DefaultsTemplate, app)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, valuesDocument{source: "defaults", content: defaults})
+	}
+	if conditions := ValidateValuesFromNamespaces(app, valuesFromSources(opts), opts.AllowedNamespaces); len(conditions) > 0 {
+		messages := make([]string, len(conditions))
+		for i, condition := range conditions {
+			messages[i] = condition.Message
+		}
+		return nil, fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	missing := make([]string, 0)
+	for _, from := range valuesFromSources(opts) {
+		doc, found, err := getValuesFromDocument(kubeclientset, app, from, opts.Cache)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			documents = append(documents, *doc)
+			continue
+		}
+		if !opts.CollectMissing {
+			return nil, fmt.Errorf("required values source %s not found", doc.source)
+		}
+		missing = append(missing, doc.source)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("required values sources not found: %s", strings.Join(missing, ", "))
+	}
+	if helm := source.Helm; helm != nil && helm.Values != "" {
+		documents = append(documents, valuesDocument{source: "spec.source.helm.values", content: helm.Values})
+	}
+	return documents, nil
+}
+
+// valuesFromSources returns the project and application values sources ordered from lowest to highest priority
+func valuesFromSources(opts HelmValuesOptions) []HelmValuesFromSource {
+	sources := make([]HelmValuesFromSource, 0, len(opts.ProjectValuesFrom)+len(opts.ValuesFrom))
+	sources = append(sources, opts.ProjectValuesFrom...)
+	return append(sources, opts.ValuesFrom...)
+}
+
+// getValuesFromDocument reads the values document referenced by the given source from the namespace of the
+// application. The fallback key is used if the rendered key does not exist. It returns false if neither key exists
+// and the reference is not optional.
+func getValuesFromDocument(kubeclientset kubernetes.Interface, app *argoappv1.Application, from HelmValuesFromSource, cache *HelmValuesCache) (*valuesDocument, bool, error) {
+	var ref *ValuesKeyRef
+	var kind string
+	switch {
+	case from.ConfigMapKeyRef != nil:
+		ref = from.ConfigMapKeyRef
+		kind = "ConfigMap"
+	case from.SecretKeyRef != nil:
+		ref = from.SecretKeyRef
+		kind = "Secret"
+	default:
+		return nil, false, fmt.Errorf("values source must reference either a ConfigMap or a Secret key")
+	}
+	namespace := valuesKeyRefNamespace(app, ref)
+	displayName := ref.Name
+	if namespace != app.Namespace {
+		displayName = fmt.Sprintf("%s/%s", namespace, ref.Name)
+	}
+	var source *valuesSourceData
+	var err error
+	if cache != nil {
+		if source, err = cache.getSource(kind, namespace, ref.Name); err != nil {
+			return nil, false, err
+		}
+	}
+	if source == nil {
+		if source, err = fetchValuesSourceData(kubeclientset, kind, namespace, ref.Name); err != nil {
+			return nil, false, err
+		}
+	}
+	var data map[string]string
+	if source != nil {
+		data = source.data
+	}
+	key, err := renderValuesTemplate("key", ref.Key, app)
+	if err != nil {
+		return nil, false, err
+	}
+	doc := &valuesDocument{source: fmt.Sprintf("%s '%s' key '%s'", kind, displayName, key)}
+	readKey := key
+	content, ok := data[key]
+	if !ok && ref.FallbackKey != "" {
+		doc.source = fmt.Sprintf("%s '%s' key '%s' or fallback key '%s'", kind, displayName, key, ref.FallbackKey)
+		readKey = ref.FallbackKey
+		content, ok = data[readKey]
+	}
+	if !ok && !ref.Optional {
+		return doc, false, nil
+	}
+	if ok && ref.Base64Decode {
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode values from %s: %v", doc.source, err)
+		}
+		content = string(decoded)
+	}
+	doc.content = content
+	if ok && cache != nil {
+		documentKey := cache.documentKey(kind, source.uid, source.resourceVersion, readKey, ref.Base64Decode)
+		if parsed, cached := cache.getDocument(documentKey); cached {
+			doc.parsed = parsed
+		} else if parsed, err := parseValues(content); err == nil {
+			cache.addDocument(documentKey, parsed)
+			doc.parsed = parsed
+		}
+	}
+	return doc, true, nil
+}
+
+// valuesKeyRefNamespace returns the namespace of the referenced ConfigMap or Secret
+func valuesKeyRefNamespace(app *argoappv1.Application, ref *ValuesKeyRef) string {
+	if ref.Namespace != "" {
+		return ref.Namespace
+	}
+	return app.Namespace
+}
+
+// valuesSourceData holds the data of a ConfigMap or Secret referenced by a values source
+type valuesSourceData struct {
+	uid             types.UID
+	resourceVersion string
+	data            map[string]string
+}
+
+// fetchValuesSourceData returns the data of the ConfigMap or Secret with the given name, or nil if it does not exist
+func fetchValuesSourceData(kubeclientset kubernetes.Interface, kind string, namespace string, name string) (*valuesSourceData, error) {
+	switch kind {
+	case "ConfigMap":
+		cm, err := kubeclientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if apierr.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return &valuesSourceData{uid: cm.UID, resourceVersion: cm.ResourceVersion, data: cm.Data}, nil
+	default:
+		secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if apierr.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		data := make(map[string]string)
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+		return &valuesSourceData{uid: secret.UID, resourceVersion: secret.ResourceVersion, data: data}, nil
+	}
+}
+
+// expandValuesVariables substitutes the configured variables into the string values of the parsed values documents.
+// References to undefined variables are left untouched unless strict mode is enabled, in which case all of them are
+// reported in one error.
+func expandValuesVariables(documents []valuesDocument, opts HelmValuesOptions) ([]valuesDocument, error) {
+	if len(opts.Variables) == 0 && !opts.StrictVariables {
+		return documents, nil
+	}
+	undefined := make(map[string]bool)
+	expanded := make([]valuesDocument, len(documents))
+	for i, doc := range documents {
+		expanded[i] = doc
+		if !valuesVariableRx.MatchString(doc.content) {
+			continue
+		}
+		values := doc.parsed
+		if values == nil {
+			var err error
+			if values, err = parseValues(doc.content); err != nil {
+				return nil, fmt.Errorf("failed to parse values from %s: %v", doc.source, err)
+			}
+		}
+		substituted := false
+		values = expandStringValues(values, func(ref string) string {
+			name := valuesVariableRx.FindStringSubmatch(ref)[1]
+			if value, ok := opts.Variables[name]; ok {
+				substituted = true
+				return value
+			}
+			undefined[name] = true
+			return ref
+		}).(map[string]interface{})
+		if !substituted {
+			continue
+		}
+		content, err := yaml.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = valuesDocument{source: doc.source, content: string(content), parsed: values}
+	}
+	if opts.StrictVariables && len(undefined) > 0 {
+		names := make([]string, 0, len(undefined))
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("values reference undefined variables: %s", strings.Join(names, ", "))
+	}
+	return expanded, nil
+}
+
+// expandStringValues returns a copy of the parsed value in which every variable reference of every string value is
+// replaced using the given function. Keys are left untouched.
+func expandStringValues(value interface{}, replace func(ref string) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			expanded[key] = expandStringValues(item, replace)
+		}
+		return expanded
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded[i] = expandStringValues(item, replace)
+		}
+		return expanded
+	case string:
+		return valuesVariableRx.ReplaceAllStringFunc(v, replace)
+	default:
+		return value
+	}
+}
+
+// renderValuesTemplate renders the given values template using the metadata of the application
+func renderValuesTemplate(name string, text string, app *argoappv1.Application) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s values template: %v", name, err)
+	}
+	data := valuesTemplateData{
+		Name:        app.Name,
+		Namespace:   app.Namespace,
+		Project:     app.Spec.GetProject(),
+		Labels:      app.Labels,
+		Destination: app.Spec.Destination,
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render %s values template: %v", name, err)
+	}
+	return out.String(), nil
+}
+
+// mergeValues deep merges the overrides into the given values. Nested maps are merged recursively while any
+// other value in overrides replaces the existing value.
+func mergeValues(values map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	for k, v := range overrides {
+		if overrideMap, ok := v.(map[string]interface{}); ok {
+			if existingMap, ok := values[k].(map[string]interface{}); ok {
+				values[k] = mergeValues(existingMap, overrideMap)
+				continue
+			}
+		}
+		values[k] = v
+	}
+	return values
+}
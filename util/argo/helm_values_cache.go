@@ -0,0 +1,79 @@
+package argo
+
+import (
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// HelmValuesCache caches the parsed values documents read from the ConfigMaps and Secrets referenced by Helm values
+// sources. Objects are looked up in the informer caches backing the listers, so resolving values does not contact the
+// API server, and documents are keyed by the UID and resource version of their object so an updated object is parsed
+// again. Objects missing from the informer caches, e.g. because they were just created, are fetched from the API
+// server. The cache is safe for concurrent use and holds a bounded number of documents, evicting the least recently
+// used ones.
+type HelmValuesCache struct {
+	configMaps corev1listers.ConfigMapLister
+	secrets    corev1listers.SecretLister
+	documents  *lru.Cache
+}
+
+// NewHelmValuesCache returns a cache holding up to size parsed documents of the objects served by the given listers
+func NewHelmValuesCache(size int, configMaps corev1listers.ConfigMapLister, secrets corev1listers.SecretLister) (*HelmValuesCache, error) {
+	documents, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &HelmValuesCache{configMaps: configMaps, secrets: secrets, documents: documents}, nil
+}
+
+// getSource returns the data of the ConfigMap or Secret from the informer cache, or nil if it is not cached
+func (c *HelmValuesCache) getSource(kind string, namespace string, name string) (*valuesSourceData, error) {
+	switch kind {
+	case "ConfigMap":
+		cm, err := c.configMaps.ConfigMaps(namespace).Get(name)
+		if err != nil {
+			if apierr.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return &valuesSourceData{uid: cm.UID, resourceVersion: cm.ResourceVersion, data: cm.Data}, nil
+	default:
+		secret, err := c.secrets.Secrets(namespace).Get(name)
+		if err != nil {
+			if apierr.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		data := make(map[string]string)
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+		return &valuesSourceData{uid: secret.UID, resourceVersion: secret.ResourceVersion, data: data}, nil
+	}
+}
+
+// documentKey identifies a values document by the UID and resource version of its object and the key holding it
+func (c *HelmValuesCache) documentKey(kind string, uid types.UID, resourceVersion string, key string, base64Decode bool) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%t", kind, uid, resourceVersion, key, base64Decode)
+}
+
+// getDocument returns a copy of the parsed values document cached under the given key
+func (c *HelmValuesCache) getDocument(key string) (map[string]interface{}, bool) {
+	parsed, ok := c.documents.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return runtime.DeepCopyJSON(parsed.(map[string]interface{})), true
+}
+
+// addDocument caches a copy of the parsed values document under the given key
+func (c *HelmValuesCache) addDocument(key string, parsed map[string]interface{}) {
+	c.documents.Add(key, runtime.DeepCopyJSON(parsed))
+}
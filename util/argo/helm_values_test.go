@@ -0,0 +1,899 @@
+package argo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	testcore "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/argoproj/argo-cd/common"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+func newHelmValuesApp(values string) *argoappv1.Application {
+	return &argoappv1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "argocd"},
+		Spec: argoappv1.ApplicationSpec{
+			Source: argoappv1.ApplicationSource{
+				RepoURL: "https://github.com/argoproj/argocd-example-apps",
+				Path:    "helm-guestbook",
+				Helm:    &argoappv1.ApplicationSourceHelm{Values: values},
+			},
+			Destination: argoappv1.ApplicationDestination{Server: "https://kubernetes.default.svc", Namespace: "guestbook"},
+		},
+	}
+}
+
+func TestResolveHelmValues(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\nimage:\n  tag: v2\n")
+	values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: v2\nreplicaCount: 2\n", values)
+}
+
+func TestResolveHelmValues_TopLevelMapping(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"mapping.yaml": "image:\n  tag: v1\n", "sequence.yaml": "- image\n- tag\n", "scalar.yaml": "v1\n"},
+	})
+	resolve := func(key string) (string, error) {
+		opts := HelmValuesOptions{ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: key}}}}
+		return ResolveHelmValues(kubeclientset, newHelmValuesApp(""), opts)
+	}
+	t.Run("Mapping", func(t *testing.T) {
+		values, err := resolve("mapping.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v1\n", values)
+	})
+	t.Run("Sequence", func(t *testing.T) {
+		_, err := resolve("sequence.yaml")
+		assert.EqualError(t, err, "failed to parse values from ConfigMap 'guestbook-values' key 'sequence.yaml': values must be a mapping at the top level, not a sequence")
+	})
+	t.Run("Scalar", func(t *testing.T) {
+		_, err := resolve("scalar.yaml")
+		assert.EqualError(t, err, "failed to parse values from ConfigMap 'guestbook-values' key 'scalar.yaml': values must be a mapping at the top level, not a scalar")
+	})
+}
+
+func TestResolveHelmValues_DefaultsTemplate(t *testing.T) {
+	t.Run("RenderedWithAppMetadata", func(t *testing.T) {
+		app := newHelmValuesApp("image:\n  tag: v2\n")
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{
+			DefaultsTemplate: "fullnameOverride: {{ .Name }}\nnamespace: {{ .Destination.Namespace }}\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n  tag: v1\n",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "fullnameOverride: guestbook\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n  tag: v2\nnamespace: guestbook\n", values)
+	})
+	t.Run("EmptyTemplate", func(t *testing.T) {
+		app := newHelmValuesApp("image:\n  tag: v2\n")
+		withoutTemplate, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{})
+		assert.NoError(t, err)
+		withEmptyTemplate, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{DefaultsTemplate: ""})
+		assert.NoError(t, err)
+		assert.Equal(t, withoutTemplate, withEmptyTemplate)
+	})
+	t.Run("InvalidTemplate", func(t *testing.T) {
+		_, err := ResolveHelmValues(fake.NewSimpleClientset(), newHelmValuesApp(""), HelmValuesOptions{DefaultsTemplate: "name: {{ .Missing }}"})
+		assert.Error(t, err)
+	})
+}
+
+func TestRefreshRequired(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\n")
+	values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{})
+	assert.NoError(t, err)
+	checksum := ResolvedValuesChecksum(values, &app.Spec.Source)
+	app.Annotations = map[string]string{common.AnnotationKeyValuesChecksum: checksum}
+
+	t.Run("MatchingChecksum", func(t *testing.T) {
+		assert.False(t, RefreshRequired(app, checksum))
+	})
+	t.Run("DifferentChecksum", func(t *testing.T) {
+		assert.True(t, RefreshRequired(app, ResolvedValuesChecksum("replicaCount: 3\n", &app.Spec.Source)))
+	})
+	t.Run("NoRecordedChecksum", func(t *testing.T) {
+		assert.True(t, RefreshRequired(newHelmValuesApp(""), checksum))
+	})
+}
+
+func TestResolveHelmValues_Variables(t *testing.T) {
+	app := newHelmValuesApp("ingress:\n  host: ${APP}.${DOMAIN}\nimage:\n  tag: ${TAG}\n")
+	t.Run("AllDefined", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{
+			Variables:       map[string]string{"APP": "guestbook", "DOMAIN": "example.com", "TAG": "v1"},
+			StrictVariables: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v1\ningress:\n  host: guestbook.example.com\n", values)
+	})
+	t.Run("StrictReportsAllUndefined", func(t *testing.T) {
+		_, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{
+			Variables:       map[string]string{"APP": "guestbook"},
+			StrictVariables: true,
+		})
+		assert.EqualError(t, err, "values reference undefined variables: DOMAIN, TAG")
+	})
+	t.Run("LenientKeepsUndefined", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{Variables: map[string]string{"APP": "guestbook", "TAG": "v1"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v1\ningress:\n  host: guestbook.${DOMAIN}\n", values)
+	})
+	t.Run("Injection", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), newHelmValuesApp("securityContext:\n  name: ${NAME}\n  privileged: false\n"), HelmValuesOptions{
+			Variables: map[string]string{"NAME": "x\n  privileged: true # "},
+		})
+		assert.NoError(t, err)
+		parsed, err := parseValues(values)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"securityContext": map[string]interface{}{"name": "x\n  privileged: true # ", "privileged": false},
+		}, parsed)
+		assert.Empty(t, ValidateHelmSecurityValues(values, []string{"privileged"}))
+	})
+	t.Run("KeysAndCommentsUntouched", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), newHelmValuesApp("# uses ${TAG}\n${TAG}: latest\n"), HelmValuesOptions{
+			Variables: map[string]string{"TAG": "v1"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "${TAG}: latest\n", values)
+	})
+}
+
+func TestResolveHelmValues_ValuesFrom(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n"},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-secret-values", Namespace: "argocd"},
+		Data:       map[string][]byte{"values.yaml": []byte("password: foo\n")},
+	})
+	app := newHelmValuesApp("image:\n  tag: v2\n")
+
+	t.Run("Merged", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}},
+				{SecretKeyRef: &ValuesKeyRef{Name: "guestbook-secret-values", Key: "values.yaml"}},
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "missing", Key: "values.yaml", Optional: true}},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n  tag: v2\npassword: foo\nreplicaCount: 3\n", values)
+	})
+	t.Run("SingleMissing", func(t *testing.T) {
+		_, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "missing.yaml"}},
+				{SecretKeyRef: &ValuesKeyRef{Name: "missing", Key: "values.yaml"}},
+			},
+		})
+		assert.EqualError(t, err, "required values source ConfigMap 'guestbook-values' key 'missing.yaml' not found")
+	})
+	t.Run("CollectMissing", func(t *testing.T) {
+		_, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "missing.yaml"}},
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}},
+				{SecretKeyRef: &ValuesKeyRef{Name: "missing", Key: "values.yaml"}},
+			},
+			CollectMissing: true,
+		})
+		assert.EqualError(t, err, "required values sources not found: ConfigMap 'guestbook-values' key 'missing.yaml', Secret 'missing' key 'values.yaml'")
+	})
+}
+
+func TestValidateForbiddenHelmValues(t *testing.T) {
+	forbidden := []ForbiddenRule{
+		{Key: "securityContext.privileged", Value: "true"},
+		{Key: "hostNetwork"},
+	}
+	t.Run("ForbiddenKey", func(t *testing.T) {
+		conditions := ValidateForbiddenHelmValues("hostNetwork: false\n", forbidden)
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "Helm values key 'hostNetwork' is forbidden",
+		}}, conditions)
+	})
+	t.Run("ForbiddenValue", func(t *testing.T) {
+		conditions := ValidateForbiddenHelmValues("securityContext:\n  privileged: true\n", forbidden)
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "Helm values key 'securityContext.privileged' must not be set to 'true'",
+		}}, conditions)
+	})
+	t.Run("Clean", func(t *testing.T) {
+		assert.Empty(t, ValidateForbiddenHelmValues("securityContext:\n  privileged: false\nreplicaCount: 1\n", forbidden))
+	})
+}
+
+func TestResolveHelmValuesForSource(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\n"},
+	})
+	app := newHelmValuesApp("image:\n  tag: v2\n")
+	source := &argoappv1.ApplicationSource{
+		RepoURL: "https://github.com/argoproj/argocd-example-apps",
+		Path:    "helm-guestbook",
+		Helm:    &argoappv1.ApplicationSourceHelm{Values: "image:\n  tag: v3\n"},
+	}
+	opts := HelmValuesOptions{
+		DefaultsTemplate: "fullnameOverride: {{ .Name }}\n",
+		ValuesFrom:       []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}},
+	}
+
+	t.Run("ExplicitSource", func(t *testing.T) {
+		values, err := ResolveHelmValuesForSource(kubeclientset, app, source, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "fullnameOverride: guestbook\nimage:\n  tag: v3\nreplicaCount: 3\n", values)
+	})
+	t.Run("PrimarySource", func(t *testing.T) {
+		values, err := ResolveHelmValuesForSource(kubeclientset, app, &app.Spec.Source, opts)
+		assert.NoError(t, err)
+		expected, err := ResolveHelmValues(kubeclientset, app, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, values)
+	})
+	t.Run("NoHelmOptions", func(t *testing.T) {
+		values, err := ResolveHelmValuesForSource(kubeclientset, app, &argoappv1.ApplicationSource{}, HelmValuesOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "{}\n", values)
+	})
+	t.Run("NoHelmOptionsOmitEmpty", func(t *testing.T) {
+		values, err := ResolveHelmValuesForSource(kubeclientset, app, &argoappv1.ApplicationSource{}, HelmValuesOptions{OmitEmpty: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "", values)
+	})
+}
+
+func TestValidateHelmValuesAgainstSchema(t *testing.T) {
+	schema := []byte(`{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "image": {
+      "type": "object",
+      "properties": {"tag": {"type": "string"}}
+    }
+  }
+}`)
+	t.Run("Valid", func(t *testing.T) {
+		assert.Empty(t, ValidateHelmValuesAgainstSchema("replicaCount: 2\nimage:\n  tag: v1\n", schema))
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		conditions := ValidateHelmValuesAgainstSchema("replicaCount: 0\nimage:\n  tag: 1\n", schema)
+		assert.ElementsMatch(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "Helm values do not match the chart schema: replicaCount in body should be greater than or equal to 1",
+		}, {
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: `Helm values do not match the chart schema: image.tag in body must be of type string: "number"`,
+		}}, conditions)
+	})
+	t.Run("MissingRequired", func(t *testing.T) {
+		conditions := ValidateHelmValuesAgainstSchema("replicaCount: 2\n", schema)
+		assert.Len(t, conditions, 1)
+		assert.Contains(t, conditions[0].Message, "image")
+	})
+}
+
+func TestEffectiveValueSources(t *testing.T) {
+	t.Run("AllSourceKinds", func(t *testing.T) {
+		app := newHelmValuesApp("replicaCount: 2\n")
+		app.Spec.Source.Helm.ValueFiles = []string{"values.yaml", "values-production.yaml"}
+		sources := EffectiveValueSources(&app.Spec, HelmValuesOptions{
+			DefaultsTemplate: "fullnameOverride: {{ .Name }}\n",
+			ValuesFrom: []HelmValuesFromSource{
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}},
+				{SecretKeyRef: &ValuesKeyRef{Name: "guestbook-secret-values", Key: "values.yaml", Optional: true}},
+			},
+		})
+		assert.Equal(t, []ValueSourceDescriptor{
+			{Type: ValueSourceTypeDefaults},
+			{Type: ValueSourceTypeFile, Name: "values.yaml"},
+			{Type: ValueSourceTypeFile, Name: "values-production.yaml"},
+			{Type: ValueSourceTypeConfigMap, Name: "guestbook-values", Key: "values.yaml"},
+			{Type: ValueSourceTypeSecret, Name: "guestbook-secret-values", Key: "values.yaml", Optional: true},
+			{Type: ValueSourceTypeInline},
+		}, sources)
+	})
+	t.Run("InlineOnly", func(t *testing.T) {
+		app := newHelmValuesApp("replicaCount: 2\n")
+		assert.Equal(t, []ValueSourceDescriptor{{Type: ValueSourceTypeInline}}, EffectiveValueSources(&app.Spec, HelmValuesOptions{}))
+	})
+}
+
+func TestResolveHelmValues_ValuesFromFallbackKey(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data: map[string]string{
+			"values-production.yaml": "replicaCount: 3\n",
+			"default":                "replicaCount: 1\n",
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-production-values", Namespace: "argocd"},
+		Data:       map[string]string{"values-production.yaml": "replicaCount: 3\n"},
+	})
+	newApp := func(env string) *argoappv1.Application {
+		app := newHelmValuesApp("")
+		app.Labels = map[string]string{"env": env}
+		return app
+	}
+	ref := func(name string, optional bool) HelmValuesOptions {
+		return HelmValuesOptions{ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{
+			Name:        name,
+			Key:         `values-{{ index .Labels "env" }}.yaml`,
+			FallbackKey: "default",
+			Optional:    optional,
+		}}}}
+	}
+
+	t.Run("SpecificKey", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, newApp("production"), ref("guestbook-values", false))
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 3\n", values)
+	})
+	t.Run("FallbackKey", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, newApp("staging"), ref("guestbook-values", false))
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 1\n", values)
+	})
+	t.Run("NeitherKey", func(t *testing.T) {
+		_, err := ResolveHelmValues(kubeclientset, newApp("staging"), ref("guestbook-production-values", false))
+		assert.EqualError(t, err, "required values source ConfigMap 'guestbook-production-values' key 'values-staging.yaml' or fallback key 'default' not found")
+		values, err := ResolveHelmValues(kubeclientset, newApp("staging"), ref("guestbook-production-values", true))
+		assert.NoError(t, err)
+		assert.Equal(t, "{}\n", values)
+	})
+}
+
+func TestResolveHelmValuesWithReport(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n"},
+	})
+	app := newHelmValuesApp("replicaCount: 2\n")
+	values, report, err := ResolveHelmValuesWithReport(kubeclientset, app, HelmValuesOptions{
+		ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "image:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\nreplicaCount: 2\n", values)
+	assert.Equal(t, ValuesPrecedenceReport{
+		"replicaCount": {
+			Sources: []string{"ConfigMap 'guestbook-values' key 'values.yaml'", "spec.source.helm.values"},
+			Winner:  "spec.source.helm.values",
+		},
+		"image": {
+			Sources: []string{"ConfigMap 'guestbook-values' key 'values.yaml'"},
+			Winner:  "ConfigMap 'guestbook-values' key 'values.yaml'",
+		},
+	}, report)
+}
+
+func TestResolvedValuesSizeBreakdown(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\n"},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-secrets", Namespace: "argocd"},
+		Data:       map[string][]byte{"values.yaml": []byte("password: ${PASSWORD}\n")},
+	})
+	app := newHelmValuesApp("image:\n  tag: v2\n")
+	sizes, err := ResolvedValuesSizeBreakdown(kubeclientset, app, HelmValuesOptions{
+		DefaultsTemplate: "fullnameOverride: {{ .Name }}\n",
+		ValuesFrom: []HelmValuesFromSource{
+			{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}},
+			{SecretKeyRef: &ValuesKeyRef{Name: "guestbook-secrets", Key: "values.yaml"}},
+		},
+		Variables: map[string]string{"PASSWORD": "hunter2"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"defaults": 28,
+		"ConfigMap 'guestbook-values' key 'values.yaml'": 16,
+		"Secret 'guestbook-secrets' key 'values.yaml'":   18,
+		"spec.source.helm.values":                        17,
+	}, sizes)
+
+	_, err = ResolvedValuesSizeBreakdown(fake.NewSimpleClientset(), app, HelmValuesOptions{
+		ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "missing", Key: "values.yaml"}}},
+	})
+	assert.Error(t, err)
+}
+
+func TestValidateHelmValueParameterConsistency(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\nimage:\n  tag: v1\n")
+	t.Run("ConflictingOverride", func(t *testing.T) {
+		app := app.DeepCopy()
+		app.Spec.Source.Helm.Parameters = []argoappv1.HelmParameter{{Name: "image.tag", Value: "v2"}}
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionHelmParameterOverrideInfo,
+			Message: "Helm parameter 'image.tag' overrides the inline value 'v1' with 'v2'",
+		}}, ValidateHelmValueParameterConsistency(&app.Spec))
+	})
+	t.Run("NoConflict", func(t *testing.T) {
+		app := app.DeepCopy()
+		app.Spec.Source.Helm.Parameters = []argoappv1.HelmParameter{
+			{Name: "replicaCount", Value: "2"},
+			{Name: "ingress.enabled", Value: "true"},
+		}
+		assert.Empty(t, ValidateHelmValueParameterConsistency(&app.Spec))
+	})
+}
+
+func TestResolveHelmValues_Cache(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd", UID: "1", ResourceVersion: "1"},
+		Data:       map[string]string{"values.yaml": "image:\n  tag: v1\n"},
+	}))
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-overrides", Namespace: "argocd", UID: "2", ResourceVersion: "1"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\n"},
+	})
+	gets := 0
+	kubeclientset.PrependReactor("get", "configmaps", func(action testcore.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	valuesCache, err := NewHelmValuesCache(10, corev1listers.NewConfigMapLister(indexer), corev1listers.NewSecretLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})))
+	assert.NoError(t, err)
+	opts := HelmValuesOptions{
+		ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}},
+		Cache:      valuesCache,
+	}
+	app := newHelmValuesApp("")
+
+	t.Run("Hit", func(t *testing.T) {
+		seeded := opts
+		seeded.SeedKey = "image.seed"
+		for i := 0; i < 2; i++ {
+			_, err := ResolveHelmValues(kubeclientset, app, seeded)
+			assert.NoError(t, err)
+			values, err := ResolveHelmValues(kubeclientset, app, opts)
+			assert.NoError(t, err)
+			assert.Equal(t, "image:\n  tag: v1\n", values)
+		}
+		assert.Equal(t, 0, gets)
+		assert.Equal(t, 1, valuesCache.documents.Len())
+	})
+	t.Run("ResourceVersionChanged", func(t *testing.T) {
+		assert.NoError(t, indexer.Update(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd", UID: "1", ResourceVersion: "2"},
+			Data:       map[string]string{"values.yaml": "image:\n  tag: v2\n"},
+		}))
+		values, err := ResolveHelmValues(kubeclientset, app, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v2\n", values)
+		assert.Equal(t, 0, gets)
+		assert.Equal(t, 2, valuesCache.documents.Len())
+	})
+	t.Run("NotInInformerCache", func(t *testing.T) {
+		opts := opts
+		opts.ValuesFrom = []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-overrides", Key: "values.yaml"}}}
+		values, err := ResolveHelmValues(kubeclientset, app, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 3\n", values)
+		assert.Equal(t, 1, gets)
+	})
+}
+
+func TestResolveHelmValues_ProjectValuesFrom(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-project-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 1\nimage:\n  pullPolicy: Always\n"},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\n"},
+	})
+	values, err := ResolveHelmValues(kubeclientset, newHelmValuesApp("image:\n  tag: v2\n"), HelmValuesOptions{
+		ProjectValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "default-project-values", Key: "values.yaml"}}},
+		ValuesFrom:        []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "image:\n  pullPolicy: Always\n  tag: v2\nreplicaCount: 3\n", values)
+}
+
+func TestValidateValueFileGlobs(t *testing.T) {
+	files := []string{"Chart.yaml", "values.yaml", "values/production.yaml", "values/staging.yaml"}
+	app := newHelmValuesApp("")
+	t.Run("Matching", func(t *testing.T) {
+		app.Spec.Source.Helm.ValueFiles = []string{"values.yaml", "values/*.yaml"}
+		assert.Empty(t, ValidateValueFileGlobs(&app.Spec, files))
+	})
+	t.Run("NotMatching", func(t *testing.T) {
+		app.Spec.Source.Helm.ValueFiles = []string{"overrides/*.yaml"}
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionUnmatchedValueFilesWarning,
+			Message: "Helm value file glob 'overrides/*.yaml' does not match any file",
+		}}, ValidateValueFileGlobs(&app.Spec, files))
+	})
+}
+
+func TestResolvedValuesChecksum(t *testing.T) {
+	resolvedValues := "replicaCount: 3\n"
+	source := &newHelmValuesApp("replicaCount: 2\n").Spec.Source
+	checksum := ResolvedValuesChecksum(resolvedValues, source)
+	assert.Equal(t, checksum, ResolvedValuesChecksum(resolvedValues, source.DeepCopy()))
+
+	t.Run("InlineValuesChanged", func(t *testing.T) {
+		source := source.DeepCopy()
+		source.Helm.Values = "replicaCount: 2 # scaled by HPA\n"
+		assert.NotEqual(t, checksum, ResolvedValuesChecksum(resolvedValues, source))
+	})
+	t.Run("ResolvedValuesChanged", func(t *testing.T) {
+		assert.NotEqual(t, checksum, ResolvedValuesChecksum("replicaCount: 4\n", source))
+	})
+	t.Run("NoHelmSource", func(t *testing.T) {
+		assert.Equal(t, ResolvedValuesChecksum(resolvedValues, nil), ResolvedValuesChecksum(resolvedValues, &argoappv1.ApplicationSource{}))
+	})
+}
+
+func TestManifestCacheKey(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\n")
+	checksum := ResolvedValuesChecksum("replicaCount: 2\n", &app.Spec.Source)
+	cacheKey := func(spec *argoappv1.ApplicationSpec, checksum string) string {
+		key, err := ManifestCacheKey(spec, checksum)
+		assert.NoError(t, err)
+		return key
+	}
+	key := cacheKey(&app.Spec, checksum)
+
+	t.Run("Stable", func(t *testing.T) {
+		assert.Equal(t, key, cacheKey(app.Spec.DeepCopy(), checksum))
+	})
+	t.Run("SpecChanged", func(t *testing.T) {
+		spec := app.Spec.DeepCopy()
+		spec.Source.TargetRevision = "v1.0.0"
+		assert.NotEqual(t, key, cacheKey(spec, checksum))
+	})
+	t.Run("ValuesChanged", func(t *testing.T) {
+		assert.NotEqual(t, key, cacheKey(&app.Spec, ResolvedValuesChecksum("replicaCount: 3\n", &app.Spec.Source)))
+	})
+}
+
+func TestManifestGenerationKey(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\n")
+	revision := "0f0ee5a5eb8f1fb0c0cd1ec2ab4e4ea0fd5b2d1b"
+	checksum := ResolvedValuesChecksum("replicaCount: 2\n", &app.Spec.Source)
+	generationKey := func(spec *argoappv1.ApplicationSpec, revision string, checksum string) string {
+		key, err := ManifestGenerationKey(spec, revision, checksum)
+		assert.NoError(t, err)
+		return key
+	}
+	key := generationKey(&app.Spec, revision, checksum)
+
+	t.Run("Stable", func(t *testing.T) {
+		assert.Equal(t, key, generationKey(app.Spec.DeepCopy(), revision, checksum))
+	})
+	t.Run("SpecChanged", func(t *testing.T) {
+		spec := app.Spec.DeepCopy()
+		spec.Destination.Namespace = "staging"
+		assert.NotEqual(t, key, generationKey(spec, revision, checksum))
+	})
+	t.Run("RevisionChanged", func(t *testing.T) {
+		assert.NotEqual(t, key, generationKey(&app.Spec, "a5eb8f1fb0c0cd1ec2ab4e4ea0fd5b2d1b0f0ee5", checksum))
+	})
+	t.Run("ValuesChanged", func(t *testing.T) {
+		assert.NotEqual(t, key, generationKey(&app.Spec, revision, ResolvedValuesChecksum("replicaCount: 3\n", &app.Spec.Source)))
+	})
+	t.Run("InputsNotConcatenated", func(t *testing.T) {
+		assert.NotEqual(t, generationKey(&app.Spec, "ab", "c"), generationKey(&app.Spec, "a", "bc"))
+	})
+}
+
+func TestValidateNoTemplateInjection(t *testing.T) {
+	values := "ingress:\n  hosts:\n  - '{{ .Values.host }}'\nname: guestbook\n"
+	t.Run("Warning", func(t *testing.T) {
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionTemplateInjectionWarning,
+			Message: "Helm values key 'ingress.hosts[0]' contains template markers",
+		}}, ValidateNoTemplateInjection(values, false))
+	})
+	t.Run("Error", func(t *testing.T) {
+		conditions := ValidateNoTemplateInjection(values, true)
+		assert.Len(t, conditions, 1)
+		assert.Equal(t, argoappv1.ApplicationConditionInvalidSpecError, conditions[0].Type)
+	})
+	t.Run("Clean", func(t *testing.T) {
+		assert.Empty(t, ValidateNoTemplateInjection("ingress:\n  hosts:\n  - guestbook.example.com\n", false))
+	})
+}
+
+func TestResolveHelmValues_SeedKey(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\n")
+	t.Run("Enabled", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{SeedKey: "global.seed"})
+		assert.NoError(t, err)
+		parsed, err := parseValues(values)
+		assert.NoError(t, err)
+		_, ok := lookupValue(parsed, "global.seed")
+		assert.True(t, ok)
+		again, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{SeedKey: "global.seed"})
+		assert.NoError(t, err)
+		assert.Equal(t, values, again)
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 2\n", values)
+	})
+	t.Run("AlreadySet", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), newHelmValuesApp("global:\n  seed: 42\n"), HelmValuesOptions{SeedKey: "global.seed"})
+		assert.NoError(t, err)
+		assert.Equal(t, "global:\n  seed: 42\n", values)
+	})
+	t.Run("ParentNotMap", func(t *testing.T) {
+		_, err := ResolveHelmValues(fake.NewSimpleClientset(), newHelmValuesApp("image: nginx:1.17\n"), HelmValuesOptions{SeedKey: "image.seed"})
+		assert.EqualError(t, err, "failed to inject seed under 'image.seed': value at 'image' is not a map")
+	})
+}
+
+func TestResolveHelmValues_Transform(t *testing.T) {
+	app := newHelmValuesApp("replicaCount: 2\n")
+	t.Run("InjectKey", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{
+			Transform: func(values map[string]interface{}) (map[string]interface{}, error) {
+				values["fullnameOverride"] = "guestbook"
+				return values, nil
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "fullnameOverride: guestbook\nreplicaCount: 2\n", values)
+	})
+	t.Run("Error", func(t *testing.T) {
+		_, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{
+			Transform: func(values map[string]interface{}) (map[string]interface{}, error) {
+				return nil, fmt.Errorf("fullnameOverride is required")
+			},
+		})
+		assert.EqualError(t, err, "failed to transform values: fullnameOverride is required")
+	})
+}
+
+func TestValidateValuesFromNamespaces(t *testing.T) {
+	app := newHelmValuesApp("")
+	allowed := []string{"shared-values"}
+	t.Run("AllowedNamespace", func(t *testing.T) {
+		valuesFrom := []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "org-values", Namespace: "shared-values", Key: "values.yaml"}}}
+		assert.Empty(t, ValidateValuesFromNamespaces(app, valuesFrom, allowed))
+	})
+	t.Run("DisallowedNamespace", func(t *testing.T) {
+		valuesFrom := []HelmValuesFromSource{{SecretKeyRef: &ValuesKeyRef{Name: "db-credentials", Namespace: "kube-system", Key: "values.yaml"}}}
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "values source Secret 'db-credentials' references namespace 'kube-system' which is not permitted",
+		}}, ValidateValuesFromNamespaces(app, valuesFrom, allowed))
+	})
+	t.Run("UnspecifiedNamespace", func(t *testing.T) {
+		valuesFrom := []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}}
+		assert.Empty(t, ValidateValuesFromNamespaces(app, valuesFrom, allowed))
+	})
+}
+
+func TestResolveHelmValues_ValuesFromNamespace(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "org-values", Namespace: "shared-values"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\n"},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "kube-system"},
+		Data:       map[string][]byte{"values.yaml": []byte("password: secret\n")},
+	})
+	opts := HelmValuesOptions{
+		ValuesFrom:        []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "org-values", Namespace: "shared-values", Key: "values.yaml"}}},
+		AllowedNamespaces: []string{"shared-values"},
+	}
+	t.Run("AllowedNamespace", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, newHelmValuesApp(""), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 3\n", values)
+	})
+	t.Run("NoAllowedNamespaces", func(t *testing.T) {
+		opts := opts
+		opts.AllowedNamespaces = nil
+		_, err := ResolveHelmValues(kubeclientset, newHelmValuesApp(""), opts)
+		assert.EqualError(t, err, "values source ConfigMap 'org-values' references namespace 'shared-values' which is not permitted")
+	})
+	t.Run("DisallowedNamespace", func(t *testing.T) {
+		opts := opts
+		opts.ValuesFrom = []HelmValuesFromSource{{SecretKeyRef: &ValuesKeyRef{Name: "db-credentials", Namespace: "kube-system", Key: "values.yaml"}}}
+		_, err := ResolveHelmValues(kubeclientset, newHelmValuesApp(""), opts)
+		assert.EqualError(t, err, "values source Secret 'db-credentials' references namespace 'kube-system' which is not permitted")
+	})
+}
+
+func TestResolveHelmValues_Base64Decode(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data: map[string]string{
+			"encoded.yaml": "aW1hZ2U6CiAgdGFnOiB2Mgo=",
+			"plain.yaml":   "replicaCount: 3\n",
+			"invalid.yaml": "not base64!",
+		},
+	})
+	app := newHelmValuesApp("")
+	t.Run("EncodedAndPlainKeys", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "encoded.yaml", Base64Decode: true}},
+				{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "plain.yaml"}},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v2\nreplicaCount: 3\n", values)
+	})
+	t.Run("InvalidEncoding", func(t *testing.T) {
+		_, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "invalid.yaml", Base64Decode: true}}},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveHelmValues_AuditSink(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "replicaCount: 3\nimage:\n  tag: v1\n"},
+	})
+	app := newHelmValuesApp("replicaCount: 2\n")
+	var entries []ValuesMergeAuditEntry
+	_, err := ResolveHelmValues(kubeclientset, app, HelmValuesOptions{
+		DefaultsTemplate: "fullnameOverride: {{ .Name }}\nreplicaCount: 1\n",
+		ValuesFrom:       []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}},
+		AuditSink: func(entry ValuesMergeAuditEntry) {
+			entries = append(entries, entry)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ValuesMergeAuditEntry{
+		{Source: "defaults", Size: 44, OverriddenKeys: []string{}},
+		{Source: "ConfigMap 'guestbook-values' key 'values.yaml'", Size: 33, OverriddenKeys: []string{"replicaCount"}},
+		{Source: "spec.source.helm.values", Size: 16, OverriddenKeys: []string{"replicaCount"}},
+	}, entries)
+}
+
+func TestValidateNamespaceConsistency(t *testing.T) {
+	app := newHelmValuesApp("")
+	t.Run("Matching", func(t *testing.T) {
+		assert.Empty(t, ValidateNamespaceConsistency(&app.Spec, "namespace: guestbook\nreplicaCount: 2\n"))
+	})
+	t.Run("Conflicting", func(t *testing.T) {
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionNamespaceMismatchWarning,
+			Message: "Helm values namespace 'default' differs from the destination namespace 'guestbook'",
+		}}, ValidateNamespaceConsistency(&app.Spec, "namespace: default\n"))
+	})
+	t.Run("NotSet", func(t *testing.T) {
+		assert.Empty(t, ValidateNamespaceConsistency(&app.Spec, "global:\n  namespace: default\n"))
+	})
+}
+
+func TestHasResolvedValues(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		hasValues, err := HasResolvedValues(fake.NewSimpleClientset(), newHelmValuesApp(""), HelmValuesOptions{})
+		assert.NoError(t, err)
+		assert.False(t, hasValues)
+	})
+	t.Run("EmptyDocuments", func(t *testing.T) {
+		hasValues, err := HasResolvedValues(fake.NewSimpleClientset(), newHelmValuesApp("{}\n"), HelmValuesOptions{DefaultsTemplate: "# no defaults\n"})
+		assert.NoError(t, err)
+		assert.False(t, hasValues)
+	})
+	t.Run("NonEmpty", func(t *testing.T) {
+		hasValues, err := HasResolvedValues(fake.NewSimpleClientset(), newHelmValuesApp("replicaCount: 2\n"), HelmValuesOptions{})
+		assert.NoError(t, err)
+		assert.True(t, hasValues)
+	})
+	t.Run("MissingSource", func(t *testing.T) {
+		_, err := HasResolvedValues(fake.NewSimpleClientset(), newHelmValuesApp(""), HelmValuesOptions{
+			ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "missing", Key: "values.yaml"}}},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestOverriddenValues(t *testing.T) {
+	chartDefaults := "replicaCount: 1\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n  tag: v1\nservice:\n  type: ClusterIP\n"
+	overridden, err := OverriddenValues("replicaCount: 1\nimage:\n  repository: gcr.io/heptio-images/ks-guestbook-demo\n  tag: v2\nservice:\n  type: ClusterIP\ningress:\n  enabled: true\n", chartDefaults)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"image":   map[string]interface{}{"tag": "v2"},
+		"ingress": map[string]interface{}{"enabled": true},
+	}, overridden)
+
+	t.Run("Unchanged", func(t *testing.T) {
+		overridden, err := OverriddenValues(chartDefaults, chartDefaults)
+		assert.NoError(t, err)
+		assert.Empty(t, overridden)
+	})
+	t.Run("InvalidDefaults", func(t *testing.T) {
+		_, err := OverriddenValues("replicaCount: 1\n", "replicaCount: [")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateHelmSecurityValues(t *testing.T) {
+	forbidden := []string{"hostNetwork", "privileged", "hostPID"}
+	t.Run("Flagged", func(t *testing.T) {
+		values := "hostNetwork: true\nsidecars:\n- name: proxy\n  securityContext:\n    privileged: true\nhostPID: false\n"
+		assert.Equal(t, []argoappv1.ApplicationCondition{{
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "Helm values key 'hostNetwork' enables a forbidden security setting",
+		}, {
+			Type:    argoappv1.ApplicationConditionInvalidSpecError,
+			Message: "Helm values key 'sidecars[0].securityContext.privileged' enables a forbidden security setting",
+		}}, ValidateHelmSecurityValues(values, forbidden))
+	})
+	t.Run("Clean", func(t *testing.T) {
+		assert.Empty(t, ValidateHelmSecurityValues("hostNetwork: false\nsecurityContext:\n  runAsNonRoot: true\n", forbidden))
+	})
+}
+
+func TestValidateValuesDepth(t *testing.T) {
+	values := "image:\n  repository: guestbook\n  tag: v1\ningress:\n  hosts:\n  - host: guestbook.example.com\n    paths:\n    - /\n"
+	t.Run("WithinDepth", func(t *testing.T) {
+		assert.Empty(t, ValidateValuesDepth(values, 5))
+	})
+	t.Run("OverDepth", func(t *testing.T) {
+		conditions := ValidateValuesDepth(values, 3)
+		assert.Len(t, conditions, 2)
+		assert.Equal(t, argoappv1.ApplicationConditionInvalidSpecError, conditions[0].Type)
+		assert.Equal(t, "Helm values key 'ingress.hosts[0].host' is nested deeper than the maximum depth of 3", conditions[0].Message)
+		assert.Equal(t, "Helm values key 'ingress.hosts[0].paths' is nested deeper than the maximum depth of 3", conditions[1].Message)
+		assert.Len(t, ValidateValuesDepth(values, 1), 3)
+	})
+}
+
+func TestValidateReservedHelmParameters(t *testing.T) {
+	reserved := []string{"commonLabels.app\\.kubernetes\\.io/instance", "global.argocd"}
+	spec := &argoappv1.ApplicationSpec{Source: argoappv1.ApplicationSource{Helm: &argoappv1.ApplicationSourceHelm{}}}
+	t.Run("Reserved", func(t *testing.T) {
+		spec := spec.DeepCopy()
+		spec.Source.Helm.Parameters = []argoappv1.HelmParameter{{Name: "global.argocd.trackingLabel", Value: "foo"}, {Name: "global", Value: "foo"}}
+		conditions := ValidateReservedHelmParameters(spec, reserved)
+		assert.Len(t, conditions, 2)
+		assert.Equal(t, argoappv1.ApplicationConditionInvalidSpecError, conditions[0].Type)
+		assert.Contains(t, conditions[0].Message, "'global.argocd.trackingLabel'")
+		assert.Contains(t, conditions[1].Message, "'global'")
+	})
+	t.Run("Safe", func(t *testing.T) {
+		spec := spec.DeepCopy()
+		spec.Source.Helm.Parameters = []argoappv1.HelmParameter{{Name: "global.argocdUrl", Value: "foo"}, {Name: "image.tag", Value: "v1"}}
+		assert.Empty(t, ValidateReservedHelmParameters(spec, reserved))
+	})
+}
+
+func TestResolveHelmValues_StrictYAML(t *testing.T) {
+	kubeclientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook-values", Namespace: "argocd"},
+		Data:       map[string]string{"values.yaml": "image:\n  tag: v1\n  tag: v2\n"},
+	})
+	opts := HelmValuesOptions{ValuesFrom: []HelmValuesFromSource{{ConfigMapKeyRef: &ValuesKeyRef{Name: "guestbook-values", Key: "values.yaml"}}}}
+	app := newHelmValuesApp("replicaCount: 2\n")
+	t.Run("DuplicateKeyLenient", func(t *testing.T) {
+		values, err := ResolveHelmValues(kubeclientset, app, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "image:\n  tag: v2\nreplicaCount: 2\n", values)
+	})
+	t.Run("DuplicateKeyStrict", func(t *testing.T) {
+		opts := opts
+		opts.StrictYAML = true
+		_, err := ResolveHelmValues(kubeclientset, app, opts)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "ConfigMap 'guestbook-values' key 'values.yaml'")
+		assert.Contains(t, err.Error(), `key "tag" already set in map`)
+	})
+	t.Run("CleanStrict", func(t *testing.T) {
+		values, err := ResolveHelmValues(fake.NewSimpleClientset(), app, HelmValuesOptions{StrictYAML: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "replicaCount: 2\n", values)
+	})
+}
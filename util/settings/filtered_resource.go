@@ -38,7 +38,8 @@ func (r FilteredResource) matchKind(kind string) bool {
 	return len(r.Kinds) == 0
 }
 
-func (r FilteredResource) matchCluster(cluster string) bool {
+// MatchCluster returns whether the resource filter applies to the given cluster
+func (r FilteredResource) MatchCluster(cluster string) bool {
 	for _, excludedCluster := range r.Clusters {
 		if match(excludedCluster, cluster) {
 			return true
@@ -48,5 +49,5 @@ func (r FilteredResource) matchCluster(cluster string) bool {
 }
 
 func (r FilteredResource) Match(apiGroup, kind, cluster string) bool {
-	return r.matchGroup(apiGroup) && r.matchKind(kind) && r.matchCluster(cluster)
+	return r.matchGroup(apiGroup) && r.matchKind(kind) && r.MatchCluster(cluster)
 }